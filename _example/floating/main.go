@@ -125,6 +125,8 @@ func start(images map[string]tsixel.Imager) error {
 	gif := images["GIF"].(*tsixel.Animation)
 	ast := images["Astolfo"].(*tsixel.Image)
 
+	var interpolate bool
+
 	for {
 		switch ev := screen.PollEvent().(type) {
 		case *tcell.EventResize:
@@ -144,6 +146,13 @@ func start(images map[string]tsixel.Imager) error {
 			// Exit on Q.
 			case 'q':
 				return nil
+			// Toggle GIF frame interpolation on I.
+			case 'i':
+				interpolate = !interpolate
+				gif.SetInterpolation(interpolate)
+			// Toggle all graphics on G.
+			case 'g':
+				sixels.SetGraphicsEnabled(!sixels.GraphicsEnabled())
 			}
 		}
 	}
@@ -6,12 +6,15 @@ import (
 	"flag"
 	"fmt"
 	"image"
+	"image/color"
 	"io"
 	"log"
+	"math"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"strings"
 	"time"
 
 	"github.com/diamondburned/tcell-sixel/tsixel"
@@ -26,6 +29,7 @@ var (
 	height int
 	colors int = 16
 	dither bool
+	palet  string
 )
 
 func init() {
@@ -35,8 +39,8 @@ func init() {
 		fmt.Fprintf(flag.CommandLine.Output(), "\t"+
 			"The given arguments will be executed as a command.\n"+
 			"The output of the command MUST be in rgba format.\n"+
-			"A palette in CSV format must be given. Refer to the\n"+
-			"README.\n\n")
+			"A palette in CSV or ACT format may be given to skip\n"+
+			"quantizing each frame.\n\n")
 
 		fmt.Fprintln(flag.CommandLine.Output(),
 			"Flags:")
@@ -49,6 +53,7 @@ func init() {
 	flag.IntVar(&height, "h", height, "the height of each frame")
 	flag.IntVar(&colors, "c", colors, "number of colors to quantize to (2-254)")
 	flag.BoolVar(&dither, "d", dither, "enable floyd-steinberg dithering")
+	flag.StringVar(&palet, "p", palet, "path to a fixed palette in CSV or ACT format")
 	flag.Parse()
 
 	if width == 0 || height == 0 {
@@ -94,13 +99,22 @@ func main() {
 	defer cmd.Wait()
 	defer cmd.Process.Kill()
 
+	var palette color.Palette
+	if palet != "" {
+		palette, err = loadPalette(palet)
+		if err != nil {
+			log.Fatalln("failed to load palette:", err)
+		}
+	}
+
 	errorCh := make(chan error)
 
 	frameCh, cancel := startPipeline(context.TODO(), pipelineProps{
-		scale:  scale,
-		width:  width,
-		height: height,
-		colors: colors,
+		scale:   scale,
+		width:   width,
+		height:  height,
+		colors:  colors,
+		palette: palette,
 		quantizer: quantize.MedianCutQuantizer{
 			Aggregation: quantize.Mean,
 		},
@@ -127,8 +141,14 @@ func main() {
 		log.Fatalln("failed to wrap screen:", err)
 	}
 
-	dummy := newDummyImage(image.Pt(width, height))
-	sixels.AddImage(dummy)
+	// The frames are scaled before they're encoded.
+	frameSize := image.Pt(
+		int(math.Round(scale*float64(width))),
+		int(math.Round(scale*float64(height))),
+	)
+
+	raw := tsixel.NewRawSIXEL(nil, frameSize)
+	sixels.AddImage(raw)
 
 	eventCh := screenEventPipeline(screen)
 	onEvent := func(ev tcell.Event) bool {
@@ -165,7 +185,7 @@ func main() {
 			}
 
 		case frame := <-frameCh:
-			dummy.SetSIXEL(frame)
+			raw.SetSIXEL(frame, frameSize)
 			screen.Show()
 
 		case err := <-errorCh:
@@ -198,3 +218,28 @@ func screenEventPipeline(screen tcell.Screen) <-chan tcell.Event {
 
 	return ch
 }
+
+// loadPalette loads the palette file, guessing its format from its extension.
+func loadPalette(path string) (color.Palette, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var palette color.Palette
+	if strings.EqualFold(filepath.Ext(path), ".act") {
+		palette, err = tsixel.LoadPaletteACT(f)
+	} else {
+		palette, err = tsixel.LoadPaletteCSV(f)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(palette) > 254 {
+		palette = palette[:254]
+	}
+
+	return palette, nil
+}
@@ -22,6 +22,7 @@ type pipelineProps struct {
 	width     int
 	height    int
 	colors    int
+	palette   color.Palette // fixed palette, or nil to quantize
 	quantizer quantize.MedianCutQuantizer
 
 	reader io.Reader
@@ -333,8 +334,12 @@ func pipelineWorker(ctx context.Context, wg *sync.WaitGroup, state workerState)
 
 		srcImage := ticket.src
 
-		// Quantize the palette before scaling.
-		paletted.Palette = state.props.quantizer.Quantize(paletted.Palette[:0], srcImage)
+		// Quantize the palette before scaling, unless a fixed one is given.
+		if state.props.palette != nil {
+			paletted.Palette = state.props.palette
+		} else {
+			paletted.Palette = state.props.quantizer.Quantize(paletted.Palette[:0], srcImage)
+		}
 
 		if scaled != nil {
 			draw.ApproxBiLinear.Scale(
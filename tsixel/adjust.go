@@ -0,0 +1,60 @@
+package tsixel
+
+import "image"
+
+// hasAdjustments returns true if any color adjustment is set.
+func (opts ImageOpts) hasAdjustments() bool {
+	return opts.Brightness != 0 || opts.Contrast != 0 || opts.Saturation != 0
+}
+
+// adjustColors applies the brightness, contrast and saturation adjustments onto
+// the given image in place.
+func (opts ImageOpts) adjustColors(img *image.RGBA) {
+	if !opts.hasAdjustments() {
+		return
+	}
+
+	contrast := 1 + opts.Contrast
+	saturation := 1 + opts.Saturation
+
+	for i := 0; i+3 < len(img.Pix); i += 4 {
+		a := float64(img.Pix[i+3])
+		if a == 0 {
+			continue
+		}
+
+		// Un-premultiply the colors to work on the actual colors.
+		r := float64(img.Pix[i+0]) / a
+		g := float64(img.Pix[i+1]) / a
+		b := float64(img.Pix[i+2]) / a
+
+		r = (r-0.5)*contrast + 0.5 + opts.Brightness
+		g = (g-0.5)*contrast + 0.5 + opts.Brightness
+		b = (b-0.5)*contrast + 0.5 + opts.Brightness
+
+		if saturation != 1 {
+			// Rec. 601 luma.
+			l := 0.299*r + 0.587*g + 0.114*b
+
+			r = l + (r-l)*saturation
+			g = l + (g-l)*saturation
+			b = l + (b-l)*saturation
+		}
+
+		img.Pix[i+0] = premultiply(r, a)
+		img.Pix[i+1] = premultiply(g, a)
+		img.Pix[i+2] = premultiply(b, a)
+	}
+}
+
+// premultiply clamps the color value c within [0, 1] and premultiplies it with
+// the alpha value a within [0, 255].
+func premultiply(c, a float64) uint8 {
+	switch {
+	case c < 0:
+		c = 0
+	case c > 1:
+		c = 1
+	}
+	return uint8(c*a + 0.5)
+}
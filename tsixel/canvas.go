@@ -0,0 +1,145 @@
+package tsixel
+
+import (
+	"image"
+	"sync"
+)
+
+// Canvas owns a rectangular region on a Screen and contains child images
+// positioned relatively to the top-left corner of that region. Moving or hiding
+// the canvas moves or hides all of its children together, which is useful for
+// building panes of images that scroll or hide as one.
+//
+// Children are constrained to the canvas as if it were the whole screen. Since
+// a SIXEL cannot be partially drawn, children that do not entirely fit within
+// the visible part of the canvas are hidden.
+type Canvas struct {
+	l      sync.Mutex
+	screen *Screen
+
+	rect     image.Rectangle
+	hidden   bool
+	children map[Imager]*canvasChild
+}
+
+// NewCanvas creates a new canvas on the given screen that occupies the given
+// region in units of cells.
+func NewCanvas(screen *Screen, rect image.Rectangle) *Canvas {
+	return &Canvas{
+		screen:   screen,
+		rect:     rect,
+		children: map[Imager]*canvasChild{},
+	}
+}
+
+// AddImage adds an image onto the canvas. The image's position is relative to
+// the canvas. This method will not redraw.
+func (c *Canvas) AddImage(img Imager) {
+	c.l.Lock()
+	child := &canvasChild{Imager: img, canvas: c}
+	c.children[img] = child
+	c.l.Unlock()
+
+	c.screen.AddImage(child)
+}
+
+// RemoveImage removes an image from the canvas. It does not redraw.
+func (c *Canvas) RemoveImage(img Imager) {
+	c.l.Lock()
+	child, ok := c.children[img]
+	delete(c.children, img)
+	c.l.Unlock()
+
+	if ok {
+		c.screen.RemoveImage(child)
+	}
+}
+
+// Clear removes all images from the canvas. It does not redraw.
+func (c *Canvas) Clear() {
+	c.l.Lock()
+	children := c.children
+	c.children = map[Imager]*canvasChild{}
+	c.l.Unlock()
+
+	for _, child := range children {
+		c.screen.RemoveImage(child)
+	}
+}
+
+// SetRect sets the region of the canvas in units of cells. Children are moved
+// along with it. This method will not redraw.
+func (c *Canvas) SetRect(rect image.Rectangle) {
+	c.l.Lock()
+	defer c.l.Unlock()
+
+	c.rect = rect
+}
+
+// Rect returns the region of the canvas in units of cells.
+func (c *Canvas) Rect() image.Rectangle {
+	c.l.Lock()
+	defer c.l.Unlock()
+
+	return c.rect
+}
+
+// SetHidden sets whether or not the canvas and all its children are hidden.
+// This method will not redraw.
+func (c *Canvas) SetHidden(hidden bool) {
+	c.l.Lock()
+	defer c.l.Unlock()
+
+	c.hidden = hidden
+}
+
+func (c *Canvas) state() (image.Rectangle, bool) {
+	c.l.Lock()
+	defer c.l.Unlock()
+
+	return c.rect, c.hidden
+}
+
+// canvasChild wraps a child image to translate its coordinates relative to the
+// canvas.
+type canvasChild struct {
+	Imager
+	canvas *Canvas
+	hidden bool
+}
+
+// Update updates the child image as if the canvas were the whole screen, and
+// translates the returned bounds to be relative to the screen.
+func (child *canvasChild) Update(state DrawState) Frame {
+	rect, hidden := child.canvas.state()
+
+	if hidden || rect.Empty() {
+		child.hidden = true
+		return Frame{}
+	}
+
+	inner := state
+	inner.Cells = rect.Size()
+	inner.Pixels = state.PtInPixels(rect.Size())
+
+	// Translate the regions in cells onto the screen.
+	frame := child.Imager.Update(inner)
+	frame.Bounds = frame.Bounds.Add(rect.Min)
+	frame.Letterbox = frame.Letterbox.Add(rect.Min)
+	frame.Opaque = frame.Opaque.Add(rect.Min)
+
+	// Hide the child if it doesn't fit the visible part of the canvas.
+	visible := rect.Intersect(image.Rectangle{Max: state.Cells})
+	if !frame.Bounds.In(visible) {
+		child.hidden = true
+		return Frame{}
+	}
+
+	// Always redraw if we were just hidden.
+	if child.hidden {
+		child.hidden = false
+		frame.MustUpdate = true
+	}
+
+	return frame
+}
@@ -0,0 +1,147 @@
+package tsixel
+
+import (
+	"image"
+	"image/color"
+	"sync"
+	"time"
+)
+
+// crossfadeSteps is the number of blended intermediate frames drawn during a
+// crossfade.
+const crossfadeSteps = 6
+
+// crossfade is the state of an ongoing crossfade transition.
+type crossfade struct {
+	from  image.Image
+	to    image.Image
+	start time.Time // zero until the first update
+	step  int       // last drawn step
+}
+
+// stepAt returns the step of the transition at the given time along with the
+// time that the next step starts. The transition is done if the returned step
+// is crossfadeSteps.
+func (fade *crossfade) stepAt(now time.Time, duration time.Duration) (int, time.Time) {
+	if fade.start.IsZero() {
+		fade.start = now
+	}
+
+	stepDuration := duration / (crossfadeSteps + 1)
+	if stepDuration <= 0 {
+		return crossfadeSteps, now
+	}
+
+	step := int(now.Sub(fade.start) / stepDuration)
+	if step > crossfadeSteps {
+		step = crossfadeSteps
+	}
+
+	return step, fade.start.Add(time.Duration(step+1) * stepDuration)
+}
+
+// image returns the blended image for the given step.
+func (fade *crossfade) image(step int) image.Image {
+	if step >= crossfadeSteps {
+		return fade.to
+	}
+
+	return &blendImage{
+		from: fade.from,
+		to:   fade.to,
+		t:    float64(step+1) / float64(crossfadeSteps+1),
+	}
+}
+
+// blendImage lazily blends two images together. The from image is stretched to
+// the bounds of the to image, unless rect is set.
+type blendImage struct {
+	from image.Image
+	to   image.Image
+	t    float64 // 0 is from, 1 is to
+
+	// rect, if not empty, is the region that both images are blended at the
+	// same coordinates within, such as the full canvas of GIF frames. Where
+	// only one image covers a pixel, that image's pixel is used as-is.
+	rect image.Rectangle
+}
+
+func (b *blendImage) ColorModel() color.Model { return color.RGBA64Model }
+
+func (b *blendImage) Bounds() image.Rectangle {
+	if !b.rect.Empty() {
+		return b.rect
+	}
+	return b.to.Bounds()
+}
+
+func (b *blendImage) At(x, y int) color.Color {
+	if !b.rect.Empty() {
+		pt := image.Pt(x, y)
+		inFrom := pt.In(b.from.Bounds())
+		inTo := pt.In(b.to.Bounds())
+
+		switch {
+		case inFrom && inTo:
+			return b.blend(b.from.At(x, y), b.to.At(x, y))
+		case inFrom:
+			return b.from.At(x, y)
+		case inTo:
+			return b.to.At(x, y)
+		default:
+			return color.Transparent
+		}
+	}
+
+	tb := b.to.Bounds()
+	fb := b.from.Bounds()
+
+	if tb.Empty() || fb.Empty() {
+		return b.to.At(x, y)
+	}
+
+	fx := fb.Min.X + (x-tb.Min.X)*fb.Dx()/tb.Dx()
+	fy := fb.Min.Y + (y-tb.Min.Y)*fb.Dy()/tb.Dy()
+
+	return b.blend(b.from.At(fx, fy), b.to.At(x, y))
+}
+
+func (b *blendImage) blend(from, to color.Color) color.Color {
+	r1, g1, b1, a1 := from.RGBA()
+	r2, g2, b2, a2 := to.RGBA()
+
+	return color.RGBA64{
+		R: b.mix(r1, r2),
+		G: b.mix(g1, g2),
+		B: b.mix(b1, b2),
+		A: b.mix(a1, a2),
+	}
+}
+
+func (b *blendImage) mix(from, to uint32) uint16 {
+	return uint16(float64(from)*(1-b.t) + float64(to)*b.t)
+}
+
+// waker schedules delayed redraws. It must be guarded by its owner's mutex,
+// which must also be given to wakeAfter.
+type waker struct {
+	waking bool // true if a delayed redraw is scheduled
+}
+
+// wakeAfter calls delegate after the given duration to redraw the screen. It
+// does nothing if a redraw is already scheduled.
+func (w *waker) wakeAfter(l sync.Locker, d time.Duration, delegate func()) {
+	if w.waking {
+		return
+	}
+
+	w.waking = true
+
+	time.AfterFunc(d, func() {
+		l.Lock()
+		w.waking = false
+		l.Unlock()
+
+		delegate()
+	})
+}
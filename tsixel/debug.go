@@ -0,0 +1,112 @@
+package tsixel
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+// ImageStats contains the drawing statistics of an image on a Screen.
+type ImageStats struct {
+	// Bounds is the last drawn bounds of the image in units of cells.
+	Bounds image.Rectangle
+	// EncodeTime is the time taken to encode the last SIXEL.
+	EncodeTime time.Duration
+	// Bytes is the size of the last SIXEL in bytes.
+	Bytes int
+	// Redraws is the number of times the SIXEL was sent to the terminal.
+	Redraws int
+	// Degradation is how the last SIXEL was degraded to fit MaxBytes.
+	Degradation Degradation
+}
+
+// String formats the statistics into a short line.
+func (stats ImageStats) String() string {
+	size := stats.Bounds.Size()
+
+	str := fmt.Sprintf(
+		"%dx%d %s %dB #%d",
+		size.X, size.Y, stats.EncodeTime.Round(time.Microsecond), stats.Bytes, stats.Redraws,
+	)
+
+	if stats.Degradation.IsDegraded() {
+		str += " (" + stats.Degradation.String() + ")"
+	}
+
+	return str
+}
+
+// SetDebug sets whether or not the debug overlay is drawn. The overlay shows
+// each image's statistics in the top-left corner of its region. This method
+// will not redraw.
+func (s *Screen) SetDebug(debug bool) {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	s.debug = debug
+}
+
+// Stats returns the drawing statistics of all images on the screen.
+func (s *Screen) Stats() map[Imager]ImageStats {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	stats := make(map[Imager]ImageStats, len(s.images))
+	for _, img := range s.images {
+		stats[img.Imager] = img.stats()
+	}
+
+	return stats
+}
+
+func (img *drawnImage) stats() ImageStats {
+	return ImageStats{
+		Bounds:      img.frame.Bounds,
+		EncodeTime:  img.frame.EncodeTime,
+		Bytes:       len(img.frame.SIXEL),
+		Redraws:     img.redraws,
+		Degradation: img.frame.Degradation,
+	}
+}
+
+// drawDebug writes the debug overlay to be drawn directly over the drawn
+// images, since cells drawn through tcell would be covered by the SIXELs.
+func (s *Screen) drawDebug(buf *bytes.Buffer) {
+	for _, img := range s.images {
+		r := img.frame.Bounds
+		if r.Empty() {
+			continue
+		}
+
+		text := img.stats().String()
+		if len(text) > r.Dx() {
+			text = text[:r.Dx()]
+		}
+
+		writeCursorPosition(buf, r.Min)
+		buf.WriteString(text)
+	}
+}
+
+// drawDebugCells draws the debug overlay as cells over the images drawn by a
+// fallback renderer.
+func (s *Screen) drawDebugCells(screen tcell.Screen) {
+	for _, img := range s.images {
+		r := img.frame.Bounds
+		if r.Empty() {
+			continue
+		}
+
+		text := img.stats().String()
+		if len(text) > r.Dx() {
+			text = text[:r.Dx()]
+		}
+
+		for i, c := range text {
+			screen.SetContent(r.Min.X+i, r.Min.Y, c, nil, tcell.StyleDefault)
+		}
+	}
+}
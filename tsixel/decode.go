@@ -0,0 +1,349 @@
+package tsixel
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+)
+
+// ErrInvalidSIXEL is returned if the SIXEL data cannot be decoded.
+var ErrInvalidSIXEL = errors.New("invalid SIXEL data")
+
+// sixelMaxParam is the value that SIXEL parameters saturate at, so that huge
+// repeat counts don't overflow.
+const sixelMaxParam = 1 << 24
+
+// decodeSIXEL decodes the given SIXEL data into an image. Pixels that are not
+// drawn by the SIXEL are left transparent. Only the first SIXEL sequence in the
+// data is decoded. The image is cropped to the given maximum size in pixels, so
+// that SIXELs declaring or drawing huge sizes can't allocate huge images.
+func decodeSIXEL(b []byte, max image.Point) (*image.NRGBA, error) {
+	d, err := newSIXELDecoder(b)
+	if err != nil {
+		return nil, err
+	}
+
+	d.max = max
+
+	if err := d.decode(); err != nil {
+		return nil, err
+	}
+
+	return d.image(), nil
+}
+
+// SIXELSize returns the size in pixels of the given SIXEL data. The size is
+// read from the raster attributes if the SIXEL declares it; otherwise, the
+// whole SIXEL is scanned to measure it. A measured height counts every SIXEL
+// band, including transparent ones, so it is a multiple of SIXELHeight.
+func SIXELSize(b []byte) (image.Point, error) {
+	d, err := newSIXELDecoder(b)
+	if err != nil {
+		return image.Point{}, err
+	}
+
+	d.measure = true
+
+	if err := d.decode(); err != nil {
+		return image.Point{}, err
+	}
+
+	return d.size, nil
+}
+
+// newSIXELDecoder creates a decoder for the data after the DCS sequence.
+func newSIXELDecoder(b []byte) (*sixelDecoder, error) {
+	// Skip to the start of the DCS sequence and its parameters.
+	start := bytes.Index(b, []byte("\x1bP"))
+	if start == -1 {
+		return nil, ErrInvalidSIXEL
+	}
+	b = b[start+2:]
+
+	q := bytes.IndexByte(b, 'q')
+	if q == -1 {
+		return nil, ErrInvalidSIXEL
+	}
+
+	d := sixelDecoder{b: b[q+1:]}
+	d.palette[0] = color.NRGBA{A: 0xFF}
+
+	return &d, nil
+}
+
+type sixelDecoder struct {
+	b []byte
+	i int
+
+	// measure, if true, only measures the size without drawing.
+	measure bool
+
+	img      *image.NRGBA
+	size     image.Point // drawn size in pixels
+	max      image.Point // maximum size in pixels to draw
+	declared bool        // true if the size is from the raster attributes
+
+	palette [256]color.NRGBA
+	color   uint8
+	x, y    int
+}
+
+func (d *sixelDecoder) decode() error {
+	for d.i < len(d.b) {
+		c := d.b[d.i]
+		d.i++
+
+		switch {
+		case c == '\x1b':
+			// String terminator.
+			return nil
+
+		case c == '"':
+			// Raster attributes: Pan;Pad;Ph;Pv. Only the size is used.
+			params := d.params()
+			if len(params) == 4 && params[2] > 0 && params[3] > 0 {
+				d.size = image.Pt(params[2], params[3])
+				d.declared = true
+
+				// The declared size is all we need.
+				if d.measure {
+					return nil
+				}
+
+				d.grow(params[2], params[3])
+			}
+
+		case c == '#':
+			params := d.params()
+			if len(params) == 0 {
+				return ErrInvalidSIXEL
+			}
+
+			d.color = uint8(params[0])
+
+			if len(params) == 5 {
+				d.palette[d.color] = sixelColor(params[1], params[2], params[3], params[4])
+			}
+
+		case c == '!':
+			params := d.params()
+			if len(params) != 1 || d.i >= len(d.b) {
+				return ErrInvalidSIXEL
+			}
+
+			c = d.b[d.i]
+			d.i++
+
+			if c < '?' || c > '~' {
+				return ErrInvalidSIXEL
+			}
+
+			d.draw(c, params[0])
+
+		case c == '$':
+			d.x = 0
+
+		case c == '-':
+			d.x = 0
+			d.y += SIXELHeight
+			// Encoders only separate bands, so a band follows even if it's
+			// entirely transparent and thus empty.
+			d.band()
+
+		case c >= '?' && c <= '~':
+			d.draw(c, 1)
+
+		case c == '\r' || c == '\n':
+			continue
+
+		default:
+			return ErrInvalidSIXEL
+		}
+	}
+
+	return nil
+}
+
+// params reads the numeric parameters separated by semicolons.
+func (d *sixelDecoder) params() []int {
+	var params []int
+	var n int
+	var has bool
+
+	for ; d.i < len(d.b); d.i++ {
+		c := d.b[d.i]
+
+		if c >= '0' && c <= '9' {
+			if n = n*10 + int(c-'0'); n > sixelMaxParam {
+				n = sixelMaxParam
+			}
+			has = true
+			continue
+		}
+
+		if c != ';' {
+			break
+		}
+
+		params = append(params, n)
+		n = 0
+		has = false
+	}
+
+	if has || len(params) > 0 {
+		params = append(params, n)
+	}
+
+	return params
+}
+
+// draw draws the given SIXEL character repeated n times.
+func (d *sixelDecoder) draw(c byte, n int) {
+	bits := c - '?'
+	d.band()
+
+	if bits != 0 {
+		if !d.measure {
+			d.grow(d.x+n, d.y+SIXELHeight)
+		}
+
+		col := d.palette[d.color]
+
+		for i := 0; i < SIXELHeight; i++ {
+			if bits&(1<<i) == 0 {
+				continue
+			}
+
+			for x := d.x; x < d.x+n && !d.measure; x++ {
+				if x >= d.max.X || d.y+i >= d.max.Y {
+					break
+				}
+				d.img.SetNRGBA(x, d.y+i, col)
+			}
+
+			if d.y+i >= d.size.Y {
+				d.size.Y = d.y + i + 1
+			}
+		}
+
+		if d.x+n > d.size.X {
+			d.size.X = d.x + n
+		}
+	}
+
+	d.x += n
+}
+
+// band counts the current band into the drawn height, unless the raster
+// attributes already declared the size.
+func (d *sixelDecoder) band() {
+	if !d.declared && d.y+SIXELHeight > d.size.Y {
+		d.size.Y = d.y + SIXELHeight
+	}
+}
+
+// grow grows the image to be at least w by h pixels, up to the maximum size.
+func (d *sixelDecoder) grow(w, h int) {
+	w = minInt(w, d.max.X)
+	h = minInt(h, d.max.Y)
+
+	var old image.Rectangle
+	if d.img != nil {
+		old = d.img.Rect
+		if w <= old.Dx() && h <= old.Dy() {
+			return
+		}
+	}
+
+	// Grow in multiples to avoid reallocating for every strip.
+	if w < old.Dx()*2 {
+		w = minInt(old.Dx()*2, d.max.X)
+	}
+	if h < old.Dy()*2 {
+		h = minInt(old.Dy()*2, d.max.Y)
+	}
+
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	if d.img != nil {
+		for y := 0; y < old.Dy(); y++ {
+			copy(img.Pix[img.PixOffset(0, y):], d.img.Pix[d.img.PixOffset(0, y):d.img.PixOffset(old.Dx(), y)])
+		}
+	}
+
+	d.img = img
+}
+
+// image returns the decoded image cropped to the drawn size. Transparent bands
+// past the drawn pixels are kept.
+func (d *sixelDecoder) image() *image.NRGBA {
+	d.grow(d.size.X, d.size.Y)
+
+	return d.img.SubImage(image.Rectangle{Max: d.size}.Intersect(d.img.Rect)).(*image.NRGBA)
+}
+
+// sixelColor converts a SIXEL color definition into a color. The color space
+// is either 1 for HLS or 2 for RGB, and the components are in percent, except
+// for the hue, which is in degrees.
+func sixelColor(space, a, b, c int) color.NRGBA {
+	if space == 1 {
+		return hlsColor(a, b, c)
+	}
+
+	return color.NRGBA{
+		R: uint8(clampPercent(a) * 0xFF / 100),
+		G: uint8(clampPercent(b) * 0xFF / 100),
+		B: uint8(clampPercent(c) * 0xFF / 100),
+		A: 0xFF,
+	}
+}
+
+// hlsColor converts a SIXEL HLS color into RGB. SIXEL hues start from blue
+// instead of red, so they are rotated first.
+func hlsColor(h, l, s int) color.NRGBA {
+	hue := float64((h+240)%360) / 360
+	lum := float64(clampPercent(l)) / 100
+	sat := float64(clampPercent(s)) / 100
+
+	var q float64
+	if lum < 0.5 {
+		q = lum * (1 + sat)
+	} else {
+		q = lum + sat - lum*sat
+	}
+	p := 2*lum - q
+
+	return color.NRGBA{
+		R: uint8(hueToRGB(p, q, hue+1.0/3) * 0xFF),
+		G: uint8(hueToRGB(p, q, hue) * 0xFF),
+		B: uint8(hueToRGB(p, q, hue-1.0/3) * 0xFF),
+		A: 0xFF,
+	}
+}
+
+func hueToRGB(p, q, t float64) float64 {
+	if t < 0 {
+		t++
+	}
+	if t > 1 {
+		t--
+	}
+
+	switch {
+	case t < 1.0/6:
+		return p + (q-p)*6*t
+	case t < 1.0/2:
+		return q
+	case t < 2.0/3:
+		return p + (q-p)*(2.0/3-t)*6
+	default:
+		return p
+	}
+}
+
+func clampPercent(n int) int {
+	if n > 100 {
+		return 100
+	}
+	return n
+}
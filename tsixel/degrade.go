@@ -0,0 +1,102 @@
+package tsixel
+
+import (
+	"fmt"
+	"image"
+)
+
+const (
+	// degradeMinColors is the lowest color count that an oversized SIXEL is
+	// reduced to before its resolution is reduced instead.
+	degradeMinColors = 16
+	// degradeMinSize is the smallest size in pixels that an oversized SIXEL is
+	// reduced to. The SIXEL is kept as-is if it's still too large by then.
+	degradeMinSize = 16
+)
+
+// Degradation describes how an image was degraded to fit ImageOpts' MaxBytes.
+// The zero value means that the image was not degraded.
+type Degradation struct {
+	// Colors is the reduced number of colors, or 0 if the colors were not
+	// reduced.
+	Colors int
+	// Size is the reduced size in pixels, or zero if the resolution was not
+	// reduced. The image is drawn smaller than its requested size.
+	Size image.Point
+}
+
+// IsDegraded returns true if the image was degraded.
+func (d Degradation) IsDegraded() bool {
+	return d != Degradation{}
+}
+
+// String returns a short description of the degradation.
+func (d Degradation) String() string {
+	switch {
+	case d.Colors > 0 && d.Size != image.Point{}:
+		return fmt.Sprintf("%d colors, %dx%d", d.Colors, d.Size.X, d.Size.Y)
+	case d.Colors > 0:
+		return fmt.Sprintf("%d colors", d.Colors)
+	case d.Size != image.Point{}:
+		return fmt.Sprintf("%dx%d", d.Size.X, d.Size.Y)
+	default:
+		return "none"
+	}
+}
+
+// encode resizes and encodes the given image into a pooled encoder. If the
+// SIXEL is larger than MaxBytes, then it is encoded again with fewer colors and
+// then at a lower resolution until it fits. The caller must put the encoder
+// back once it's done with the encoder's buffer. If an error is returned, then
+// the encoder is already put back.
+func (encp *encoderPool) encode(src image.Image, sz image.Point, opts ImageOpts) (pooledEncoder, Degradation, error) {
+	enc, err := encp.encodeOnce(src, sz, opts)
+	if err != nil || opts.MaxBytes <= 0 || enc.buf.Len() <= opts.MaxBytes {
+		return enc, Degradation{}, err
+	}
+
+	// Pin down the preset so that the color count can be reduced.
+	opts = opts.withQuality()
+	opts.Quality = QualityCustom
+
+	// Fixed palettes can't be reduced.
+	reduceColors := opts.fixedPalette() == nil
+	if opts.Colors < 2 || opts.Colors > 255 {
+		opts.Colors = 255
+	}
+
+	var degraded Degradation
+
+	for enc.buf.Len() > opts.MaxBytes {
+		switch {
+		case reduceColors && opts.Colors > degradeMinColors:
+			opts.Colors /= 2
+			if opts.Colors < degradeMinColors {
+				opts.Colors = degradeMinColors
+			}
+			degraded.Colors = opts.Colors
+
+		case sz.X > degradeMinSize && sz.Y > degradeMinSize:
+			smaller := image.Pt(sz.X*3/4, sz.Y*3/4)
+			opts.clip = image.Pt(
+				opts.clip.X*smaller.X/sz.X,
+				opts.clip.Y*smaller.Y/sz.Y,
+			)
+			sz = smaller
+			degraded.Size = sz
+
+		default:
+			// Nothing else to give up, so keep the oversized SIXEL.
+			return enc, degraded, nil
+		}
+
+		encp.put(enc)
+
+		enc, err = encp.encodeOnce(src, sz, opts)
+		if err != nil {
+			return enc, Degradation{}, err
+		}
+	}
+
+	return enc, degraded, nil
+}
@@ -0,0 +1,241 @@
+package tsixel
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+// Renderer is the backend that a Screen draws its images with.
+type Renderer uint8
+
+const (
+	// RendererSIXEL draws images as SIXEL. It is the default renderer.
+	RendererSIXEL Renderer = iota
+	// RendererHalfBlock draws images as colored half-block characters through
+	// the cell API, so it works on terminals without SIXEL support. Each cell
+	// holds 2 vertically stacked pixels.
+	RendererHalfBlock
+	// RendererBraille draws images as a dithered mosaic of braille dots
+	// without colors. Each cell holds 2x4 pixels. It is useful for plots and
+	// QR codes, where shapes matter more than colors.
+	RendererBraille
+)
+
+// cellPixels returns the size of each cell in pixels that images are drawn
+// with. A zero point is returned if the renderer uses the screen's actual
+// pixel size.
+func (r Renderer) cellPixels() image.Point {
+	switch r {
+	case RendererHalfBlock:
+		return image.Pt(1, 2)
+	case RendererBraille:
+		return image.Pt(2, 4)
+	default:
+		return image.Point{}
+	}
+}
+
+// WrapInitScreenFallback wraps around an initialized tcell screen similarly to
+// WrapInitScreen, except if the screen is not capable of drawing SIXEL, then
+// the given fallback renderer is used instead. The screen must still support
+// draw interceptors and explicit syncing.
+func WrapInitScreenFallback(s tcell.Screen, fallback Renderer) (*Screen, error) {
+	screen, err := WrapInitScreen(s)
+	if err == nil {
+		return screen, nil
+	}
+
+	if !errors.Is(err, ErrNoDirectDrawer) && !errors.Is(err, ErrNoPixelDimensions) {
+		return nil, err
+	}
+
+	return wrapInitScreen(s, fallback)
+}
+
+// Renderer returns the renderer that the screen draws its images with.
+func (s *Screen) Renderer() Renderer {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	return s.renderer
+}
+
+// SetRenderer sets the renderer that the screen draws its images with, and
+// then synchronizes the screen. An error is returned if RendererSIXEL is given
+// but the screen is not capable of drawing SIXEL.
+func (s *Screen) SetRenderer(r Renderer) error {
+	if r == RendererSIXEL {
+		if err := checkSIXELCapable(s.s); err != nil {
+			return err
+		}
+	}
+
+	s.l.Lock()
+	s.renderer = r
+	s.l.Unlock()
+
+	// Sync to clear the graphics drawn by the old renderer.
+	s.s.Sync()
+	return nil
+}
+
+// drawFallback draws the image using the screen's fallback renderer. Images
+// that keep their scaled pixels are drawn from them; otherwise, the SIXEL is
+// decoded again only when it changes.
+func (s *Screen) drawFallback(screen tcell.Screen, img *drawnImage) {
+	if img.frame.Err != nil || len(img.frame.SIXEL) == 0 {
+		return
+	}
+
+	switch {
+	case img.frame.pixels != nil:
+		if img.frame.MustUpdate || img.pixels != img.frame.pixels {
+			img.redraws++
+		}
+
+		img.pixels = img.frame.pixels
+		img.pixelsOf = nil
+
+	case img.frame.MustUpdate || !sameBuffer(img.pixelsOf, img.frame.SIXEL):
+		size := img.frame.Bounds.Size()
+		cell := s.renderer.cellPixels()
+
+		pixels, err := decodeSIXEL(img.frame.SIXEL, image.Pt(size.X*cell.X, size.Y*cell.Y))
+		if err != nil {
+			img.pixels = nil
+			img.pixelsOf = nil
+			drawErrorPlaceholder(screen, img.frame.Bounds, err)
+			return
+		}
+
+		img.pixels = pixels
+		img.pixelsOf = img.frame.SIXEL
+		img.redraws++
+	}
+
+	switch s.renderer {
+	case RendererHalfBlock:
+		drawHalfBlocks(screen, img.frame.Bounds.Min, img.pixels)
+	case RendererBraille:
+		drawBraille(screen, img.frame.Bounds.Min, img.pixels)
+	}
+}
+
+// drawHalfBlocks draws the image as half-block characters with its top-left
+// corner at the given cell. Cells that are fully transparent are left as-is.
+func drawHalfBlocks(screen tcell.Screen, pos image.Point, img *image.NRGBA) {
+	size := img.Rect.Size()
+
+	for y := 0; y < size.Y; y += 2 {
+		for x := 0; x < size.X; x++ {
+			top := img.NRGBAAt(img.Rect.Min.X+x, img.Rect.Min.Y+y)
+			bot := color.NRGBA{}
+			if y+1 < size.Y {
+				bot = img.NRGBAAt(img.Rect.Min.X+x, img.Rect.Min.Y+y+1)
+			}
+
+			r := '▀'
+			style := tcell.StyleDefault
+
+			switch {
+			case isOpaque(top):
+				style = style.Foreground(cellColor(top))
+				if isOpaque(bot) {
+					style = style.Background(cellColor(bot))
+				}
+			case isOpaque(bot):
+				r = '▄'
+				style = style.Foreground(cellColor(bot))
+			default:
+				continue
+			}
+
+			screen.SetContent(pos.X+x, pos.Y+y/2, r, nil, style)
+		}
+	}
+}
+
+// brailleDots maps each pixel within a 2x4 cell to its braille dot.
+var brailleDots = [4][2]rune{
+	{0x01, 0x08},
+	{0x02, 0x10},
+	{0x04, 0x20},
+	{0x40, 0x80},
+}
+
+// bayer4x4 is the threshold map for ordered dithering.
+var bayer4x4 = [4][4]int{
+	{0, 8, 2, 10},
+	{12, 4, 14, 6},
+	{3, 11, 1, 9},
+	{15, 7, 13, 5},
+}
+
+// drawBraille draws the image as braille characters with its top-left corner
+// at the given cell. Bright pixels are drawn as dots using ordered dithering.
+// Cells that are fully transparent are left as-is.
+func drawBraille(screen tcell.Screen, pos image.Point, img *image.NRGBA) {
+	size := img.Rect.Size()
+
+	for y := 0; y < size.Y; y += 4 {
+		for x := 0; x < size.X; x += 2 {
+			var dots rune
+			var opaque bool
+
+			for dy := 0; dy < 4 && y+dy < size.Y; dy++ {
+				for dx := 0; dx < 2 && x+dx < size.X; dx++ {
+					c := img.NRGBAAt(img.Rect.Min.X+x+dx, img.Rect.Min.Y+y+dy)
+					if !isOpaque(c) {
+						continue
+					}
+
+					opaque = true
+
+					// Scale the threshold to be in-between 0 and 255.
+					threshold := bayer4x4[(y+dy)%4][(x+dx)%4]*16 + 8
+					if luminance(c) > threshold {
+						dots |= brailleDots[dy][dx]
+					}
+				}
+			}
+
+			if !opaque {
+				continue
+			}
+
+			screen.SetContent(pos.X+x/2, pos.Y+y/4, 0x2800+dots, nil, tcell.StyleDefault)
+		}
+	}
+}
+
+// luminance returns the perceived brightness of the color from 0 to 255.
+func luminance(c color.NRGBA) int {
+	return (299*int(c.R) + 587*int(c.G) + 114*int(c.B)) / 1000
+}
+
+func isOpaque(c color.NRGBA) bool {
+	return c.A >= 0x80
+}
+
+func cellColor(c color.NRGBA) tcell.Color {
+	return tcell.NewRGBColor(int32(c.R), int32(c.G), int32(c.B))
+}
+
+// fallbackPixels copies the scaled image for fallback renderers. The top and
+// left are padded by the given offset with transparent pixels, similarly to
+// the SIXEL.
+func fallbackPixels(img *image.RGBA, offset image.Point) *image.NRGBA {
+	dst := image.NewNRGBA(image.Rectangle{Max: img.Rect.Size().Add(offset)})
+	draw.Draw(dst, dst.Rect.Add(offset), img, img.Rect.Min, draw.Src)
+	return dst
+}
+
+// sameBuffer returns true if both byte slices share the same backing array and
+// length.
+func sameBuffer(a, b []byte) bool {
+	return len(a) == len(b) && len(a) > 0 && &a[0] == &b[0]
+}
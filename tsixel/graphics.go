@@ -0,0 +1,32 @@
+package tsixel
+
+// SetGraphicsEnabled sets whether the screen draws its images, and then
+// synchronizes the screen. While disabled, no images are drawn or updated, the
+// regions that they were drawn onto are cleared, and the main resize pipeline
+// is paused, so applications can draw text placeholders instead. Images are
+// restored once graphics are enabled again. Graphics are enabled by default.
+func (s *Screen) SetGraphicsEnabled(enabled bool) {
+	s.l.Lock()
+
+	if s.noGraphics == !enabled {
+		s.l.Unlock()
+		return
+	}
+
+	s.noGraphics = !enabled
+	s.holdPipeline()
+
+	s.l.Unlock()
+
+	// Sync to clear the drawn images or to draw them again.
+	s.s.Sync()
+}
+
+// GraphicsEnabled returns true if the screen draws its images. Refer to
+// SetGraphicsEnabled.
+func (s *Screen) GraphicsEnabled() bool {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	return !s.noGraphics
+}
@@ -2,9 +2,13 @@ package tsixel
 
 import (
 	"bytes"
+	"errors"
 	"image"
+	"image/color"
 	"sync"
+	"time"
 
+	"github.com/gdamore/tcell/v2"
 	"github.com/mattn/go-sixel"
 	"golang.org/x/image/draw"
 )
@@ -20,11 +24,110 @@ type ImageOpts struct {
 	// KeepRatio, if true, will maintain the aspect ratio of the image when it's
 	// scaled down to fit the size. The image will be anchored on the top left.
 	KeepRatio bool
+	// Letterbox, if true, fills the cells of the requested region that are
+	// not covered by the image with LetterboxStyle, so that stale content is
+	// cleared when KeepRatio shrinks the image.
+	Letterbox bool
+	// LetterboxStyle is the style of the letterbox cells. The default style
+	// clears the cells.
+	LetterboxStyle tcell.Style
+	// Redraw is the policy of the image when the cells under it change.
+	// Refer to the RedrawPolicy constants for more information.
+	Redraw RedrawPolicy
 	// Dither, if true, will apply dithering onto the image.
 	Dither bool
+	// Colors is the number of colors to quantize the image to. It can be
+	// in-between 2 and 255. If Colors is 0, then 255 is used.
+	Colors int
+	// Grayscale, if true, maps the image onto a fixed palette of gray levels
+	// instead of quantizing it. The number of levels is Colors-1, or
+	// DefaultGrayLevels if Colors is not set or a Quality preset is used.
+	Grayscale bool
+	// Monochrome, if true, maps the image onto a fixed black and white
+	// palette. It takes precedence over Grayscale.
+	Monochrome bool
+	// Palette, if not empty, maps the image onto the given fixed palette
+	// instead of quantizing it, which keeps colors consistent across images
+	// and frames. It takes precedence over Monochrome and Grayscale. Only the
+	// first 254 colors are used. Refer to LoadPaletteCSV and LoadPaletteACT.
+	Palette color.Palette
+	// Brightness is added onto each color channel. It ranges from -1 to 1,
+	// and 0 leaves the image unchanged.
+	Brightness float64
+	// Contrast scales each color channel around the middle gray. It ranges
+	// from -1 (flat gray) upwards, and 0 leaves the image unchanged.
+	Contrast float64
+	// Saturation scales each color away from its gray level. It ranges from
+	// -1 (grayscale) upwards, and 0 leaves the image unchanged.
+	Saturation float64
+	// Quantizer is the color quantizer used to generate the palette of the
+	// image. If Quantizer is nil, then DefaultQuantizer is used.
+	Quantizer draw.Quantizer
+	// Quality, if not QualityCustom, overrides Scaler, Colors, Dither and
+	// Quantizer with a preset that favors either speed or fidelity. Refer to
+	// the Quality constants for more information.
+	Quality Quality
+	// IntegerScale, if true, only scales the image by whole multiples of its
+	// size using nearest-neighbor, or divides it by a whole number if it
+	// doesn't fit. The image is centered within the requested region. It is
+	// meant for pixel art and emulator frames, and it overrides Scaler,
+	// KeepRatio and Snap.
+	IntegerScale bool
+	// Snap, if true, shrinks the image to the nearest size that is a whole
+	// number of cells and SIXEL strips, so that it fills its cells exactly.
+	// This is useful for pixel art, which would otherwise get a blurry
+	// partial cell on its edges. It replaces SIXEL rounding. Refer to
+	// DrawState's SnapPt. If KeepRatio is true, then both sides are shrunk
+	// by the same factor, so only the side that shrinks the most fills its
+	// cells exactly.
+	Snap bool
 	// NoRounding disables SIXEL rounding. This is useful if the image sizes
 	// are dynamically calculated manually and are expected to be consistent.
 	NoRounding bool
+	// Overflow is the policy of the image when it does not fit within the
+	// screen. Refer to the Overflow constants for more information.
+	Overflow Overflow
+	// EdgeMargin is the margin in cells that the image keeps from the right
+	// and bottom edges of the screen. If EdgeMargin is zero, then
+	// DefaultEdgeMargin is used. A negative component disables the margin on
+	// that side, so NoEdgeMargin disables it entirely. It is ignored if
+	// NoRounding is true.
+	EdgeMargin image.Point
+	// Crossfade, if not zero, is the duration of the crossfade transition
+	// drawn when the image's source is replaced. Only Image supports it.
+	Crossfade time.Duration
+	// MaxBytes, if not zero, is the maximum size of the encoded SIXEL in
+	// bytes. Larger SIXELs are encoded again with fewer colors and then at a
+	// lower resolution until they fit, which is reported in the frame's
+	// Degradation.
+	MaxBytes int
+	// MaxFPS, if not zero, limits how often the image is redrawn to at most
+	// MaxFPS times per second. Updates in-between are coalesced into the
+	// latest one. This keeps a fast animation or a frequently replaced source
+	// from saturating slow connections.
+	MaxFPS float64
+	// Priority is the priority class of the image's resizing jobs. Images that
+	// are not immediately visible, such as thumbnails, should use
+	// PriorityBackground.
+	Priority JobPriority
+
+	// obscure is the mode to obscure the image with. It is set by Obscured.
+	obscure BlurMode
+	// offset is the clamped offset in pixels to pad the image with. It is set
+	// by SetPixelOffset.
+	offset image.Point
+	// clip is the size of the visible part of the image in pixels if the
+	// Overflow policy is OverflowClip.
+	clip image.Point
+	// scroll is the point in the source image that an unscaled image is
+	// clipped from. It is set by Marquee.
+	scroll image.Point
+	// transparent, if true, keeps the transparent pixels of the image
+	// transparent instead of quantizing them. It is set by Mosaic.
+	transparent bool
+	// pixels, if true, keeps the scaled pixels of the image alongside the
+	// SIXEL for fallback renderers. It is set from the DrawState.
+	pixels bool
 }
 
 // imageState is a container for common image properties and synchronizations.
@@ -39,8 +142,17 @@ type imageState struct {
 	// converted in the last stage.
 	imgCells  image.Point
 	imgPixels image.Point
+	pxOffset  image.Point // requested offset in pixels
+	scroll    image.Point // requested scroll point in pixels
+	shift     image.Point // offset in cells to center the image
 
 	sstate DrawState // screen state
+	err    error     // last encoding error
+
+	encTime  time.Duration // last encoding duration
+	degraded Degradation   // last encoding degradation
+	rate     rateLimiter
+	gen      uint64 // generation of the latest job
 }
 
 func newImageState(srcSize image.Point, opts ImageOpts) imageState {
@@ -50,6 +162,25 @@ func newImageState(srcSize image.Point, opts ImageOpts) imageState {
 	}
 }
 
+// cloneInto copies the options and the requested bounds into the given image
+// state. The screen state is not copied.
+func (img *imageState) cloneInto(dst *imageState) {
+	img.l.Lock()
+	defer img.l.Unlock()
+
+	dst.srcSize = img.srcSize
+	dst.opts = img.opts
+	dst.bounds = img.bounds
+	dst.pxOffset = img.pxOffset
+}
+
+// nextGeneration increments the image's generation for a new job, so results
+// of older jobs are discarded.
+func (img *imageState) nextGeneration() uint64 {
+	img.gen++
+	return img.gen
+}
+
 func (img *imageState) setSrcSize(srcSize image.Point) {
 	img.srcSize = srcSize
 	img.imgCells = image.Point{}
@@ -114,27 +245,37 @@ func (img *imageState) BoundsPx() image.Rectangle {
 	return img.sstate.RectInPixels(img.imageBounds(), !img.opts.NoRounding)
 }
 
+// Geometry returns the requested bounds, the drawn bounds and the drawn size in
+// pixels of the image.
+func (img *imageState) Geometry() Geometry {
+	img.l.Lock()
+	defer img.l.Unlock()
+
+	return Geometry{
+		Requested: img.bounds,
+		Cells:     img.imageBounds(),
+		Pixels:    img.imgPixels,
+	}
+}
+
 // maxBounds returns the bounds for the maximum region.
 func (img *imageState) maxBounds() image.Rectangle {
+	if img.opts.Overflow != OverflowShrink {
+		return img.bounds
+	}
+
 	// Don't draw the image touching the screen border to prevent weird
 	// wrapping if we're rounding for SIXEL. Most applications that need SIXEL
 	// rounding would also require strict positioning, and that means no
 	// wrapping over, so we use that condition.
-	var offset image.Point
-	if !img.opts.NoRounding {
-		offset = image.Pt(4, 2)
-	}
-
-	return img.bounds.Intersect(image.Rectangle{
-		Max: img.sstate.Cells.Sub(offset),
-	})
+	return img.bounds.Intersect(img.screenBounds())
 }
 
 // imageBounds returns the bounds for the current image.
 func (img *imageState) imageBounds() image.Rectangle {
 	return image.Rectangle{
-		Min: img.bounds.Min,
-		Max: img.bounds.Min.Add(img.imgCells),
+		Min: img.bounds.Min.Add(img.shift),
+		Max: img.bounds.Min.Add(img.shift).Add(img.imgCells),
 	}
 }
 
@@ -142,28 +283,88 @@ func (img *imageState) imageBounds() image.Rectangle {
 // size is unchanged.
 func (img *imageState) updateSize(state DrawState) bool {
 	img.sstate = state
+	img.opts.pixels = state.renderer != RendererSIXEL
 
 	// Recalculate the new image size in pixels.
-	newImgRtPx := state.RectInPixels(img.maxBounds(), !img.opts.NoRounding)
-
-	if img.opts.KeepRatio {
-		newImgRtPx.Max = newImgRtPx.Min.Add(maxSize(img.srcSize, newImgRtPx.Size()))
+	newImgRtPx := fitRect(state, img.maxBounds(), img.srcSize, img.opts)
+	offset := img.pxOffset
+
+	// Center integer-scaled images using whole cells, and then pad the rest.
+	var shift image.Point
+	if img.opts.IntegerScale {
+		box := integerBox(state, img.maxBounds(), img.opts).Size()
+		var pad image.Point
+		shift, pad = integerCenter(state.CellSize(), newImgRtPx.Size(), box)
+		offset = offset.Add(pad)
 	}
 
+	offset = clampOffset(offset, state.CellSize())
+	clip := img.clipSize(state, newImgRtPx)
+
 	// Check if we had the same size as before. Since we try to keep the aspect
 	// ratio, we could check if both points have a common equal size. Don't
 	// bother resizing if yes.
-	if ptOverlapOneSide(img.imgPixels, newImgRtPx.Size()) {
+	if offset == img.opts.offset && clip == img.opts.clip && shift == img.shift &&
+		img.scroll == img.opts.scroll && ptOverlapOneSide(img.imgPixels, newImgRtPx.Size()) {
+
 		return false
 	}
 
-	// Update the image size.
+	// Update the image size. The padding from the offset may take up another
+	// cell.
+	img.opts.offset = offset
+	img.opts.clip = clip
+	img.opts.scroll = img.scroll
+	img.shift = shift
 	img.imgPixels = newImgRtPx.Size()
-	img.imgCells = state.RectInCells(newImgRtPx).Size()
+
+	drawn := img.imgPixels
+	if img.opts.Overflow == OverflowClip {
+		drawn = clip
+	}
+
+	img.imgCells = state.PtInCells(drawn.Add(offset))
 
 	return true
 }
 
+// applyOpts sets the fields of the frame that come from the image's options.
+func (img *imageState) applyOpts(frame *Frame, state DrawState) {
+	frame.Redraw = img.opts.Redraw
+	img.limitRate(frame, state)
+
+	if img.opts.Letterbox {
+		frame.Letterbox = img.bounds.Intersect(image.Rectangle{Max: img.sstate.Cells})
+		frame.LetterboxStyle = img.opts.LetterboxStyle
+	}
+}
+
+// fitRect calculates the region in pixels that an image with the given source
+// size occupies when it is drawn within the given rectangle in cells.
+func fitRect(state DrawState, rect image.Rectangle, srcSize image.Point, opts ImageOpts) image.Rectangle {
+	if opts.IntegerScale {
+		rectPx := integerBox(state, rect, opts)
+		rectPx.Max = rectPx.Min.Add(integerSize(srcSize, rectPx.Size()))
+		return rectPx
+	}
+
+	rectPx := state.RectInPixels(rect, !opts.NoRounding && !opts.Snap)
+
+	if opts.KeepRatio {
+		rectPx.Max = rectPx.Min.Add(maxSize(srcSize, rectPx.Size()))
+	}
+
+	if opts.Snap && !rectPx.Empty() {
+		if opts.KeepRatio {
+			rectPx.Max = rectPx.Min.Add(snapRatio(state.CellSize(), rectPx.Size()))
+		} else {
+			rectPx.Max = rectPx.Min.Add(snapPt(state.CellSize(), rectPx.Size(), true))
+		}
+	}
+
+	return rectPx
+}
+
 // TODO: make StaticImage for a fully static no resizing image impl.
 
 // Image represents a SIXEL image. This image holds the source image and resizes
@@ -173,15 +374,29 @@ func (img *imageState) updateSize(state DrawState) bool {
 //
 // An image is not thread-safe, so it is not safe to share it across multiple
 // screens, even with the same dimensions. This is because the synchronization
-// of an image entirely depends on the screen it is on.
+// of an image entirely depends on the screen it is on. To display the same
+// image on multiple screens, use CloneFor.
 type Image struct {
-	src image.Image
-	buf []byte
+	src    image.Image
+	buf    []byte
+	pixels *image.NRGBA // scaled pixels of buf for fallback renderers
+
+	// pooled buffer backing buf, and the replaced buffers to be released on
+	// the next update once the screen no longer uses them
+	sixBuf *SIXELBuffer
+	stale  []*SIXELBuffer
 
 	imageState
 
 	// use for drawing after async resize
 	updated bool
+	shown   uint64 // generation of the job that made buf
+
+	fade  *crossfade // non-nil if transitioning
+	waker waker
+
+	// cells fully covered by the current SIXEL relative to the image
+	covered image.Rectangle
 }
 
 // NewImage creates a new SIXEL image from the given image.
@@ -198,57 +413,178 @@ func NewImage(img image.Image, opts ImageOpts) *Image {
 	}
 }
 
+// Clone creates a new image with the same source image, options and requested
+// bounds. The source image is shared, but the clone has its own state, so it
+// can be added onto another screen.
+func (img *Image) Clone() *Image {
+	clone := &Image{}
+	img.cloneInto(&clone.imageState)
+
+	img.l.Lock()
+	clone.src = img.src
+	img.l.Unlock()
+
+	return clone
+}
+
+// CloneFor clones the image and adds the clone onto the given screen. It
+// implements Cloner.
+func (img *Image) CloneFor(screen *Screen) Imager {
+	clone := img.Clone()
+	screen.AddImage(clone)
+	return clone
+}
+
 // SetImage sets the new image source into the currnet image. The processing is
 // done immediately, so the sizes returned by the methods are guaranteed to be
-// updated.
+// updated. If the Crossfade option is set, then the image transitions into the
+// new source over the next draws.
 func (img *Image) SetImage(newSrc image.Image) {
 	img.l.Lock()
 	defer img.l.Unlock()
 
+	if img.opts.Crossfade > 0 && img.buf != nil {
+		from := img.src
+		// Continue from the final image if we're already transitioning.
+		if img.fade != nil {
+			from = img.fade.to
+		}
+
+		// The transition starts on the next draw.
+		img.fade = &crossfade{from: from, to: newSrc, step: -1}
+		img.updated = true
+		return
+	}
+
 	img.src = newSrc
 	img.setSrcSize(newSrc.Bounds().Size())
 	img.update(img.sstate)
 	img.updated = true
 }
 
+// setSource sets a new source image without updating the image, so that it's
+// only resized on the next Update. Unlike SetImage, it never crossfades.
+func (img *Image) setSource(newSrc image.Image) {
+	img.l.Lock()
+	defer img.l.Unlock()
+
+	img.src = newSrc
+	img.setSrcSize(newSrc.Bounds().Size())
+	img.updated = true
+}
+
 // Update updates the image's state to the given screen, resizes the src image,
 // and updates the internal buffer. It implements the Imager interface.
 func (img *Image) Update(state DrawState) Frame {
 	img.l.Lock()
 	defer img.l.Unlock()
 
-	return img.update(state)
+	// The screen is done with the previous frame, so the replaced buffers can
+	// be released. This must only be done here, since SetImage also updates
+	// the image while the screen may still be drawing the previous frame.
+	img.releaseStale()
+
+	frame := img.update(state)
+	img.applyOpts(&frame, state)
+
+	return frame
 }
 
 func (img *Image) update(state DrawState) Frame {
+	if state.IsEmpty() {
+		return Frame{}
+	}
+
 	updated := img.updated
 	img.updated = false
 
 	frame := Frame{
-		Bounds:     img.imageBounds(),
-		SIXEL:      img.buf,
-		MustUpdate: state.Sync || updated,
+		Bounds:      img.imageBounds(),
+		SIXEL:       img.buf,
+		MustUpdate:  state.Sync || updated,
+		Err:         img.err,
+		EncodeTime:  img.encTime,
+		Degradation: img.degraded,
+		Opaque:      img.covered.Add(img.imageBounds().Min),
+		pixels:      img.pixels,
 	}
 
-	if !img.updateSize(state) {
+	img.updateCrossfade(state)
+
+	if !img.updateSize(state) && (!state.Offline || img.buf != nil) {
+		return frame
+	}
+
+	// Encode right here if we're offline, since nothing will redraw us later.
+	if state.Offline {
+		start := time.Now()
+
+		img.shown = img.nextGeneration()
+		img.setBuffer(nil)
+		img.buf, img.degraded, img.err = resizerMain.pool.do(img.src, img.imgPixels, img.opts)
+		img.encTime = time.Since(start)
+		img.setCovered(img.err == nil && opaqueSource(img.src, img.imgPixels, img.opts), img.drawnPixels(), img.opts)
+
+		frame.Bounds = img.imageBounds()
+		frame.SIXEL = img.buf
+		frame.MustUpdate = true
+		frame.Err = img.err
+		frame.EncodeTime = img.encTime
+		frame.Degradation = img.degraded
+		frame.Opaque = img.covered.Add(img.imageBounds().Min)
+
 		return frame
 	}
 
 	resizerMain.QueueJob(ResizerJob{
-		SrcImg:  img.src,
-		Options: img.opts,
-		NewSize: img.imgPixels,
+		SrcImg:     img.src,
+		Options:    img.opts,
+		NewSize:    img.imgPixels,
+		Generation: img.nextGeneration(),
+
+		Priority: img.opts.Priority,
+		Owner:    img,
 
-		Done: func(job ResizerJob, out []byte) {
+		Superseded: func(job ResizerJob) bool {
 			img.l.Lock()
+			defer img.l.Unlock()
 
-			// Ensure this is the latest image and geometry.
-			if job.SrcImg != img.src || job.NewSize != img.imgPixels {
+			return !img.isLatestJob(job)
+		},
+
+		DoneBuffer: func(job ResizerJob, out *SIXELBuffer, err error) {
+			opaque := err == nil && opaqueSource(job.SrcImg, job.NewSize, job.Options)
+
+			img.l.Lock()
+
+			// Ensure this is the latest image and geometry, and that nothing
+			// newer is shown yet.
+			if !img.isNewerJob(job) {
 				img.l.Unlock()
+				out.Release()
 				return
 			}
 
-			img.buf = out
+			// Forget the size if the job was dropped, so that the next update
+			// queues it again.
+			if errors.Is(err, ErrJobDropped) {
+				img.imgPixels = image.Point{}
+				img.l.Unlock()
+				return
+			}
+
+			// Keep the old SIXEL on error; the screen will draw a placeholder
+			// over it instead.
+			img.err = err
+			img.encTime = job.Elapsed
+			if err == nil {
+				img.shown = job.Generation
+				img.degraded = job.Degradation
+				img.setBuffer(out)
+				img.pixels = job.pixels
+				img.setCovered(opaque, img.drawnPixels(), job.Options)
+			}
+
 			img.updated = true
 
 			img.l.Unlock()
@@ -260,6 +596,125 @@ func (img *Image) update(state DrawState) Frame {
 	return frame
 }
 
+// updateCrossfade steps the crossfade transition. The source image is replaced
+// with the blended image of the current step, and a redraw is scheduled for the
+// next step.
+func (img *Image) updateCrossfade(state DrawState) {
+	if img.fade == nil {
+		return
+	}
+
+	// Skip the transition entirely if we're offline.
+	step := crossfadeSteps
+	next := state.Time
+
+	if !state.Offline {
+		step, next = img.fade.stepAt(state.Time, img.opts.Crossfade)
+	}
+
+	if step != img.fade.step {
+		img.fade.step = step
+		img.src = img.fade.image(step)
+		// Reset the size so that the new step is resized.
+		img.setSrcSize(img.src.Bounds().Size())
+	}
+
+	if step >= crossfadeSteps {
+		img.fade = nil
+		return
+	}
+
+	img.waker.wakeAfter(&img.l, next.Sub(state.Time), state.Delegate)
+}
+
+// isLatestJob returns true if the given job was made for the current image and
+// geometry.
+func (img *Image) isLatestJob(job ResizerJob) bool {
+	return job.Generation == img.gen && img.isNewerJob(job)
+}
+
+// isNewerJob returns true if the given job was made for the current image and
+// geometry, and it is newer than the job of the shown SIXEL. Unlike
+// isLatestJob, the job may be for an older scroll point, so that a scrolling
+// image keeps moving even if its encodes are slower than its steps.
+func (img *Image) isNewerJob(job ResizerJob) bool {
+	return job.Generation > img.shown &&
+		job.SrcImg == img.src &&
+		job.NewSize == img.imgPixels &&
+		job.Options.obscure == img.opts.obscure &&
+		job.Options.offset == img.opts.offset &&
+		job.Options.clip == img.opts.clip
+}
+
+func (img *Image) setObscured(mode BlurMode) {
+	img.l.Lock()
+	defer img.l.Unlock()
+
+	if img.opts.obscure != mode {
+		img.opts.obscure = mode
+		// Drop the sharp SIXEL right away instead of drawing it until the
+		// obscured one is encoded.
+		if mode != BlurNone {
+			img.setBuffer(nil)
+		}
+		// Reset the size so that the image is encoded again.
+		img.setSrcSize(img.srcSize)
+	}
+}
+
+// setScroll sets the point in the source image that the image is clipped from.
+// The image must not be scaled. The image is encoded again on the next Update.
+func (img *imageState) setScroll(pt image.Point) {
+	img.l.Lock()
+	defer img.l.Unlock()
+
+	img.scroll = pt
+}
+
+// setBuffer sets the image's SIXEL to the given pooled buffer. The old buffer
+// is released on the next Update. A nil buffer clears the SIXEL.
+func (img *Image) setBuffer(buf *SIXELBuffer) {
+	if img.sixBuf != nil {
+		img.stale = append(img.stale, img.sixBuf)
+	}
+
+	img.sixBuf = buf
+	img.buf = nil
+	img.pixels = nil
+
+	if buf != nil {
+		img.buf = buf.Bytes()
+	}
+}
+
+// setCovered sets the cells covered by the SIXEL of the given size.
+func (img *Image) setCovered(opaque bool, size image.Point, opts ImageOpts) {
+	if !opaque {
+		img.covered = image.Rectangle{}
+		return
+	}
+
+	img.covered = coveredCells(img.sstate.CellSize(), size, opts)
+}
+
+// drawnPixels returns the size of the current SIXEL in pixels, which is smaller
+// than the requested size if it was degraded.
+func (img *imageState) drawnPixels() image.Point {
+	if img.degraded.Size != (image.Point{}) {
+		return img.degraded.Size
+	}
+	return img.imgPixels
+}
+
+func (img *Image) releaseStale() {
+	for i, buf := range img.stale {
+		buf.Release()
+		img.stale[i] = nil
+	}
+
+	img.stale = img.stale[:0]
+}
+
 // ptOverlapOneSide returns true if one side of p1 equals to p2.
 func ptOverlapOneSide(p, bound image.Point) bool {
 	return (p.X == bound.X && p.Y <= bound.Y) || (p.Y == bound.Y && p.X <= bound.X)
@@ -1,36 +1,152 @@
 package tsixel
 
 import (
+	"errors"
 	"image"
 	"image/gif"
 	"time"
 )
 
+// BrowserMinDelay is the delay that browsers use for GIF frames with a delay
+// of 0 or 1 hundredth of a second.
+const BrowserMinDelay = 100 * time.Millisecond
+
+// AnimationTiming controls the playback timing of an animation.
+type AnimationTiming struct {
+	// MinDelay, if not zero, replaces the delay of frames with a delay of 0 or
+	// 1 hundredth of a second, similarly to how browsers treat them.
+	MinDelay time.Duration
+	// MaxFPS, if not zero, limits the frame rate of the animation by holding
+	// each frame for at least 1/MaxFPS seconds.
+	MaxFPS float64
+}
+
+// DefaultAnimationTiming is the timing of new animations. It treats GIFs with
+// no delays like browsers do.
+var DefaultAnimationTiming = AnimationTiming{
+	MinDelay: BrowserMinDelay,
+}
+
 type Animation struct {
 	gif      *gif.GIF
 	frames   []animationFrame
+	timing   AnimationTiming
 	lastTime time.Time // last drawn time
 
+	cacheSize  int   // 0 to cache all frames
+	cacheOrder []int // indices of encoded frames, oldest first
+
 	imageState
 
-	redraw  bool
-	frameIx int // frame index
-	loopedN int // number of times looped
+	redraw      bool
+	interpolate bool
+	frameIx     int // frame index
+	shownIx     int // shown frame index, including intermediate frames
+	loopedN     int // number of times looped
 }
 
+// animationFrame is an encoded frame. The animation keeps one for each GIF
+// frame followed by one for each intermediate frame.
 type animationFrame struct {
-	sixel []byte
-	size  image.Point
+	sixel  []byte
+	pixels *image.NRGBA // scaled pixels of sixel for fallback renderers
+	size   image.Point
+	offset image.Point
+	clip   image.Point
+	gen    uint64 // generation of the latest job
 }
 
 func NewAnimation(gif *gif.GIF, opts ImageOpts) *Animation {
 	return &Animation{
 		gif:        gif,
-		frames:     make([]animationFrame, len(gif.Image)),
+		frames:     make([]animationFrame, len(gif.Image)*2),
+		timing:     DefaultAnimationTiming,
 		imageState: newImageState(image.Pt(gif.Config.Width, gif.Config.Height), opts),
 	}
 }
 
+// Clone creates a new animation with the same GIF, options and requested
+// bounds. The decoded GIF is shared, but the clone has its own frame cache and
+// playback state, so it can be added onto another screen.
+func (anim *Animation) Clone() *Animation {
+	clone := &Animation{
+		gif:    anim.gif,
+		frames: make([]animationFrame, len(anim.gif.Image)*2),
+	}
+	anim.cloneInto(&clone.imageState)
+
+	anim.l.Lock()
+	clone.timing = anim.timing
+	clone.cacheSize = anim.cacheSize
+	clone.interpolate = anim.interpolate
+	anim.l.Unlock()
+
+	return clone
+}
+
+// CloneFor clones the animation and adds the clone onto the given screen. It
+// implements Cloner.
+func (anim *Animation) CloneFor(screen *Screen) Imager {
+	clone := anim.Clone()
+	screen.AddImage(clone)
+	return clone
+}
+
+// SetTiming sets the playback timing of the animation. This method will not
+// redraw.
+func (anim *Animation) SetTiming(timing AnimationTiming) {
+	anim.l.Lock()
+	defer anim.l.Unlock()
+
+	anim.timing = timing
+}
+
+// SetCacheSize sets the maximum number of encoded frames that the animation
+// keeps. Older frames are evicted and encoded again when they're shown. If n is
+// 0 or less, then all frames are kept, which is the default; this is the
+// fastest, but long GIFs at large sizes may use a lot of memory.
+func (anim *Animation) SetCacheSize(n int) {
+	anim.l.Lock()
+	defer anim.l.Unlock()
+
+	if n < 0 {
+		n = 0
+	}
+
+	anim.cacheSize = n
+	anim.evictFrames()
+}
+
+// cacheFrame marks the frame at the given index as the most recently encoded
+// one and evicts the oldest frames if there are too many.
+func (anim *Animation) cacheFrame(ix int) {
+	if anim.cacheSize == 0 {
+		return
+	}
+
+	for i, cached := range anim.cacheOrder {
+		if cached == ix {
+			anim.cacheOrder = append(anim.cacheOrder[:i], anim.cacheOrder[i+1:]...)
+			break
+		}
+	}
+
+	anim.cacheOrder = append(anim.cacheOrder, ix)
+	anim.evictFrames()
+}
+
+func (anim *Animation) evictFrames() {
+	if anim.cacheSize == 0 {
+		anim.cacheOrder = nil
+		return
+	}
+
+	for len(anim.cacheOrder) > anim.cacheSize {
+		anim.frames[anim.cacheOrder[0]] = animationFrame{}
+		anim.cacheOrder = anim.cacheOrder[1:]
+	}
+}
+
 // seekFrames seeks until we're at the current frame.
 func (anim *Animation) seekFrames(now time.Time) {
 	// Don't do anything if we're already over the draw limit.
@@ -45,10 +161,8 @@ func (anim *Animation) seekFrames(now time.Time) {
 
 	// TODO: optimize this to be in constant time rather than linear.
 	for {
-		delay := anim.gif.Delay[anim.frameIx] // 100ths of a second
-
 		// Accumulate the delay and the index.
-		next := anim.lastTime.Add(gifDelayDuration(delay))
+		next := anim.lastTime.Add(anim.frameDelay(anim.frameIx))
 		// Stop accumulating once we've added enough.
 		if next.After(now) {
 			break
@@ -72,57 +186,146 @@ func (anim *Animation) seekFrames(now time.Time) {
 	}
 }
 
+// frameDelay returns the delay of the frame at the given index after applying
+// the animation's timing. The delay is never shorter than a hundredth of a
+// second, so GIFs without delays don't spin.
+func (anim *Animation) frameDelay(ix int) time.Duration {
+	delay := gifDelayDuration(anim.gif.Delay[ix])
+
+	if anim.timing.MinDelay > 0 && delay <= gifDelayDuration(1) {
+		delay = anim.timing.MinDelay
+	}
+
+	if anim.timing.MaxFPS > 0 {
+		if min := time.Duration(float64(time.Second) / anim.timing.MaxFPS); delay < min {
+			delay = min
+		}
+	}
+
+	if delay < gifDelayDuration(1) {
+		delay = gifDelayDuration(1)
+	}
+
+	return delay
+}
+
 // gifDelayDuration converts delay in the unit of 100ths of a second to a
 // duration.
 func gifDelayDuration(delay int) time.Duration {
 	return time.Second / 100 * time.Duration(delay)
 }
 
+// Update updates the animation's state to the given screen and seeks to the
+// current frame. It implements the Imager interface.
 func (anim *Animation) Update(state DrawState) Frame {
 	anim.l.Lock()
 	defer anim.l.Unlock()
 
-	lastFrame := anim.frameIx
+	frame := anim.update(state)
+	anim.applyOpts(&frame, state)
+
+	return frame
+}
+
+func (anim *Animation) update(state DrawState) Frame {
+	if state.IsEmpty() {
+		return Frame{}
+	}
+
+	lastFrame := anim.shownIx
 	anim.seekFrames(state.Time)
+	anim.shownIx = anim.shownFrame(state.Time)
 
 	redraw := anim.redraw
 	anim.redraw = false
 
 	// update redraw state.
 	if !redraw {
-		redraw = lastFrame != anim.frameIx
+		redraw = lastFrame != anim.shownIx
 	}
 
-	frameSIXEL := &anim.frames[anim.frameIx]
+	frameIx := anim.shownIx
+	frameSIXEL := &anim.frames[frameIx]
 
 	anim.updateSize(state)
 
-	if frameSIXEL.sixel == nil || frameSIXEL.size != anim.imgPixels {
+	if frameSIXEL.sixel == nil || frameSIXEL.size != anim.imgPixels ||
+		frameSIXEL.offset != anim.opts.offset || frameSIXEL.clip != anim.opts.clip {
+
 		// Mark redraw.
 		redraw = true
 		// Clear out the old SIXEL.
 		frameSIXEL.sixel = nil
+		frameSIXEL.pixels = nil
 
 		// Update the size directly.
 		frameSIXEL.size = anim.imgPixels
+		frameSIXEL.offset = anim.opts.offset
+		frameSIXEL.clip = anim.opts.clip
+		frameSIXEL.gen = anim.nextGeneration()
+
+		// Encode right here if we're offline, since nothing will redraw us
+		// later.
+		if state.Offline {
+			start := time.Now()
+
+			frameSIXEL.sixel, anim.degraded, anim.err = resizerMain.pool.do(
+				anim.frameSource(frameIx), frameSIXEL.size, anim.opts,
+			)
+			anim.encTime = time.Since(start)
+			anim.cacheFrame(frameIx)
+
+			return Frame{
+				Bounds:      anim.imageBounds(),
+				SIXEL:       frameSIXEL.sixel,
+				MustUpdate:  true,
+				Err:         anim.err,
+				EncodeTime:  anim.encTime,
+				Degradation: anim.degraded,
+			}
+		}
 
 		resizerMain.QueueJob(ResizerJob{
-			SrcImg:  anim.gif.Image[anim.frameIx],
-			Options: anim.opts,
-			NewSize: frameSIXEL.size,
+			SrcImg:     anim.frameSource(frameIx),
+			Options:    anim.opts,
+			NewSize:    frameSIXEL.size,
+			Generation: frameSIXEL.gen,
+
+			Priority: anim.opts.Priority,
+			Owner:    frameSIXEL,
+
+			Superseded: func(job ResizerJob) bool {
+				anim.l.Lock()
+				defer anim.l.Unlock()
 
-			Done: func(job ResizerJob, out []byte) {
+				return !anim.isLatestJob(job, frameSIXEL)
+			},
+
+			Done: func(job ResizerJob, out []byte, err error) {
 				anim.l.Lock()
 
 				// Ensure this is the latest geometry.
-				if job.NewSize != frameSIXEL.size {
+				if !anim.isLatestJob(job, frameSIXEL) {
+					anim.l.Unlock()
+					return
+				}
+
+				// Forget the size if the job was dropped, so that the frame is
+				// queued again the next time it's shown.
+				if errors.Is(err, ErrJobDropped) {
+					frameSIXEL.size = image.Point{}
 					anim.l.Unlock()
 					return
 				}
 
 				// Update the internal SIXEL directly and mark for redrawing.
 				frameSIXEL.sixel = out
+				frameSIXEL.pixels = job.pixels
+				anim.err = err
+				anim.encTime = job.Elapsed
+				anim.degraded = job.Degradation
 				anim.redraw = true
+				anim.cacheFrame(frameIx)
 
 				anim.l.Unlock()
 
@@ -132,8 +335,36 @@ func (anim *Animation) Update(state DrawState) Frame {
 	}
 
 	return Frame{
-		Bounds:     anim.imageBounds(),
-		SIXEL:      frameSIXEL.sixel,
-		MustUpdate: redraw,
+		Bounds:      anim.imageBounds(),
+		SIXEL:       frameSIXEL.sixel,
+		MustUpdate:  redraw,
+		Err:         anim.err,
+		EncodeTime:  anim.encTime,
+		Degradation: anim.degraded,
+		pixels:      frameSIXEL.pixels,
+	}
+}
+
+// isLatestJob returns true if the given job was made for the frame's current
+// geometry.
+func (anim *Animation) isLatestJob(job ResizerJob, frame *animationFrame) bool {
+	return job.Generation == frame.gen &&
+		job.NewSize == frame.size &&
+		job.Options.obscure == anim.opts.obscure &&
+		job.Options.offset == frame.offset &&
+		job.Options.clip == frame.clip
+}
+
+func (anim *Animation) setObscured(mode BlurMode) {
+	anim.l.Lock()
+	defer anim.l.Unlock()
+
+	if anim.opts.obscure != mode {
+		anim.opts.obscure = mode
+		// Clear the frame cache so that all frames are encoded again.
+		for i := range anim.frames {
+			anim.frames[i] = animationFrame{}
+		}
+		anim.cacheOrder = nil
 	}
 }
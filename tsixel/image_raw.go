@@ -0,0 +1,102 @@
+package tsixel
+
+import (
+	"image"
+	"sync"
+)
+
+// RawSIXEL is an Imager that draws pre-encoded SIXEL data as-is. It is useful
+// for integrating external encoders. Since the SIXEL is never resized, the size
+// of the SIXEL in pixels must be given to calculate its bounds.
+type RawSIXEL struct {
+	l sync.Mutex
+
+	sixel  []byte
+	size   image.Point // in pixels
+	pos    image.Point // in cells
+	bounds image.Rectangle
+	update bool
+}
+
+var _ GeometryImager = (*RawSIXEL)(nil)
+
+// NewRawSIXEL creates a new raw SIXEL image from the given SIXEL data and its
+// size in pixels. If the size is zero, then it is parsed from the SIXEL data
+// using SIXELSize. The caller must not use the given byte slice afterwards.
+func NewRawSIXEL(b []byte, size image.Point) *RawSIXEL {
+	raw := &RawSIXEL{}
+	raw.setSIXEL(b, size)
+
+	return raw
+}
+
+// SetSIXEL sets the SIXEL data and its size in pixels. If the size is zero,
+// then it is parsed from the SIXEL data using SIXELSize. The caller must not
+// use the given byte slice afterwards. This method will not redraw.
+func (raw *RawSIXEL) SetSIXEL(b []byte, size image.Point) {
+	raw.l.Lock()
+	defer raw.l.Unlock()
+
+	raw.setSIXEL(b, size)
+	raw.update = true
+}
+
+func (raw *RawSIXEL) setSIXEL(b []byte, size image.Point) {
+	if size == (image.Point{}) && len(b) > 0 {
+		// Invalid SIXELs are drawn as-is with a zero size.
+		size, _ = SIXELSize(b)
+	}
+
+	raw.sixel = b
+	raw.size = size
+}
+
+// SetPosition sets the top-left corner of the image in units of cells. This
+// method will not redraw.
+func (raw *RawSIXEL) SetPosition(pos image.Point) {
+	raw.l.Lock()
+	defer raw.l.Unlock()
+
+	raw.pos = pos
+}
+
+// Bounds returns the bounds of the image in units of cells as of the last
+// update.
+func (raw *RawSIXEL) Bounds() image.Rectangle {
+	raw.l.Lock()
+	defer raw.l.Unlock()
+
+	return raw.bounds
+}
+
+// Geometry returns the geometry of the image. It implements GeometryImager.
+func (raw *RawSIXEL) Geometry() Geometry {
+	raw.l.Lock()
+	defer raw.l.Unlock()
+
+	return Geometry{
+		Requested: raw.bounds,
+		Cells:     raw.bounds,
+		Pixels:    raw.size,
+	}
+}
+
+// Update returns the SIXEL data. It implements Imager.
+func (raw *RawSIXEL) Update(state DrawState) Frame {
+	raw.l.Lock()
+	defer raw.l.Unlock()
+
+	update := raw.update
+	raw.update = false
+
+	raw.bounds = image.Rectangle{
+		Min: raw.pos,
+		Max: raw.pos.Add(state.PtInCells(raw.size)),
+	}
+
+	return Frame{
+		SIXEL:      raw.sixel,
+		Bounds:     raw.bounds,
+		MustUpdate: update || state.Sync,
+	}
+}
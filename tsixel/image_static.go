@@ -3,10 +3,14 @@ package tsixel
 import (
 	"image"
 	"sync"
+	"time"
+
+	"golang.org/x/image/draw"
 )
 
 // StaticImage provides the most simple implementation to draw a SIXEL image. It
-// provides no resizing.
+// provides no continuous resizing; the image is only scaled down once to fit
+// its maximum size if one is set.
 type StaticImage struct {
 	l sync.Mutex
 
@@ -19,6 +23,19 @@ type StaticImage struct {
 
 	imgPos image.Point
 	cellSz image.Point
+	imgSz  image.Point // drawn size in pixels
+	err    error       // last encoding error
+
+	encTime time.Duration // last encoding duration
+
+	maxCells image.Point // zero components are unbounded
+	scaler   draw.Scaler
+
+	// coalescing states for SetImage
+	srcUpd  bool          // src changed but not yet encoded
+	minIntv time.Duration // minimum interval between encodes
+	lastEnc time.Time     // last encoded time
+	waker   waker
 }
 
 // NewStaticImage creates a new static image from the given image.
@@ -40,31 +57,129 @@ func NewStaticImageCustom(src image.Image, dither bool, colors int) *StaticImage
 	return &static
 }
 
-// SetImage sets a new image. The image is automatically resized in the method,
-// but a redraw will not be triggered.
+// Clone creates a new static image with the same source image, encoder
+// parameters, maximum size, rate limit and position. The source image is
+// shared, but the clone has its own SIXEL buffer, so it can be added onto
+// another screen.
+func (static *StaticImage) Clone() *StaticImage {
+	static.l.Lock()
+	defer static.l.Unlock()
+
+	clone := NewStaticImageCustom(
+		static.src, static.encBuf.Encoder.Dither, static.encBuf.Encoder.Colors,
+	)
+	clone.imgPos = static.imgPos
+	clone.maxCells = static.maxCells
+	clone.scaler = static.scaler
+	clone.minIntv = static.minIntv
+
+	return clone
+}
+
+// CloneFor clones the static image and adds the clone onto the given screen. It
+// implements Cloner.
+func (static *StaticImage) CloneFor(screen *Screen) Imager {
+	clone := static.Clone()
+	screen.AddImage(clone)
+	return clone
+}
+
+// SetImage sets a new image. The image is encoded on the next Update, so
+// calling SetImage multiple times before a draw only encodes the latest image.
+// A redraw will not be triggered.
 func (static *StaticImage) SetImage(src image.Image) {
 	static.l.Lock()
 	defer static.l.Unlock()
 
-	static.setImage(src)
+	static.setImage(src, 0)
+}
+
+// SetImageRateLimited sets a new image similarly to SetImage, except the image
+// is encoded at most once every minInterval. Images set in-between are
+// coalesced, so only the most recent one is encoded. This is useful for
+// feeding the image from a capture source.
+func (static *StaticImage) SetImageRateLimited(src image.Image, minInterval time.Duration) {
+	static.l.Lock()
+	defer static.l.Unlock()
+
+	static.setImage(src, minInterval)
 }
 
-func (static *StaticImage) setImage(src image.Image) {
+func (static *StaticImage) setImage(src image.Image, minInterval time.Duration) {
 	static.src = src
+	static.srcUpd = true
+	static.minIntv = minInterval
+}
 
-	// Render right here if we have the screen state.
-	if static.cellSz != (image.Point{}) {
-		static.updateSIXEL()
+// SetMaxSize sets the maximum size of the image in units of cells. The image is
+// scaled down once using the given scaler to fit the size while keeping its
+// aspect ratio, and it is scaled again only when the cell size changes. If
+// scaler is nil, then draw.ApproxBiLinear is used. A zero component leaves that
+// side unbounded, so a zero size disables scaling.
+func (static *StaticImage) SetMaxSize(size image.Point, scaler draw.Scaler) {
+	static.l.Lock()
+	defer static.l.Unlock()
+
+	if scaler == nil {
+		scaler = draw.ApproxBiLinear
 	}
+
+	static.maxCells = size
+	static.scaler = scaler
+	static.srcUpd = true
 }
 
-func (static *StaticImage) updateSIXEL() {
+func (static *StaticImage) updateSIXEL(now time.Time) {
+	static.srcUpd = false
+	static.lastEnc = now
+
+	img := static.scaledSrc()
+	static.imgSz = img.Bounds().Size()
+
+	start := time.Now()
+
+	enc := static.encBuf.Encoder
+	img = palettedImage(img, enc.Colors, enc.Dither, DefaultQuantizer)
+
 	static.encBuf.buf.Reset()
-	static.encBuf.Encode(static.src)
+	static.err = static.encBuf.Encode(img)
 	static.buf = static.encBuf.buf.Bytes()
+	static.encTime = time.Since(start)
 	static.upd = true
 }
 
+// scaledSrc returns the source image scaled down to fit the maximum size. The
+// source image is returned as-is if it already fits.
+func (static *StaticImage) scaledSrc() image.Image {
+	srcSize := static.src.Bounds().Size()
+
+	if static.maxCells == (image.Point{}) {
+		return static.src
+	}
+
+	maxPx := image.Point{
+		X: static.maxCells.X * static.cellSz.X,
+		Y: static.maxCells.Y * static.cellSz.Y,
+	}
+
+	// Don't bound the sides without a maximum.
+	if maxPx.X <= 0 {
+		maxPx.X = srcSize.X
+	}
+	if maxPx.Y <= 0 {
+		maxPx.Y = srcSize.Y
+	}
+
+	if srcSize.X <= maxPx.X && srcSize.Y <= maxPx.Y {
+		return static.src
+	}
+
+	dst := image.NewRGBA(image.Rectangle{Max: maxSize(srcSize, maxPx)})
+	static.scaler.Scale(dst, dst.Bounds(), static.src, static.src.Bounds(), draw.Over, nil)
+
+	return dst
+}
+
 // SetPosition sets the image position.
 func (static *StaticImage) SetPosition(pt image.Point) {
 	static.l.Lock()
@@ -83,33 +198,73 @@ func (static *StaticImage) Bounds() image.Rectangle {
 	return static.bounds()
 }
 
+// Geometry returns the geometry of the static image. The requested bounds span
+// the maximum size set by SetMaxSize, so they are empty if either side is
+// unbounded.
+func (static *StaticImage) Geometry() Geometry {
+	static.l.Lock()
+	defer static.l.Unlock()
+
+	if static.cellSz == (image.Point{}) {
+		return Geometry{}
+	}
+
+	return Geometry{
+		Requested: image.Rectangle{
+			Min: static.imgPos,
+			Max: static.imgPos.Add(static.maxCells),
+		},
+		Cells:  static.bounds(),
+		Pixels: static.imgSz,
+	}
+}
+
 func (static *StaticImage) bounds() image.Rectangle {
 	return image.Rectangle{
 		Min: static.imgPos,
-		Max: static.imgPos.Add(ptInCells(static.cellSz, static.src.Bounds().Size())),
+		Max: static.imgPos.Add(ptInCells(static.cellSz, static.imgSz)),
 	}
 }
 
-// Update returns the current SIXEL data. It
+// Update returns the current SIXEL data. It encodes the latest image set if
+// needed.
 func (static *StaticImage) Update(state DrawState) Frame {
 	static.l.Lock()
 	defer static.l.Unlock()
 
+	if state.IsEmpty() {
+		return Frame{}
+	}
+
 	newCell := state.CellSize()
 	changed := static.cellSz != newCell || static.buf == nil
 
-	if changed {
+	switch {
+	case changed:
 		static.cellSz = newCell
-		static.updateSIXEL()
+		static.updateSIXEL(state.Time)
+
+	case static.srcUpd:
+		// Hold off the encoding if we've encoded too recently. A redraw is
+		// scheduled so the latest image will still be drawn.
+		if due := static.lastEnc.Add(static.minIntv); state.Time.Before(due) {
+			static.waker.wakeAfter(&static.l, due.Sub(state.Time), state.Delegate)
+			break
+		}
+
+		static.updateSIXEL(state.Time)
 	}
 
 	// Only account for static.upd after we update the SIXEL, since upd is not
 	// used for redrawing SIXELs.
 	changed = changed || static.upd
+	static.upd = false
 
 	return Frame{
 		SIXEL:      static.buf,
 		Bounds:     static.bounds(),
 		MustUpdate: changed,
+		Err:        static.err,
+		EncodeTime: static.encTime,
 	}
 }
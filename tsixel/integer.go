@@ -0,0 +1,62 @@
+package tsixel
+
+import "image"
+
+// integerBox returns the region in pixels that integer-scaled images fit
+// within. Unlike RectInPixels, the aspect ratio of the region isn't kept when
+// it is rounded, since integer scaling keeps the image's ratio anyway.
+func integerBox(state DrawState, rect image.Rectangle, opts ImageOpts) image.Rectangle {
+	rectPx := state.RectInPixels(rect, false)
+	if !opts.NoRounding {
+		rectPx.Max.Y -= rectPx.Dy() % SIXELHeight
+	}
+
+	return rectPx
+}
+
+// integerSize returns the largest size that is a whole multiple of the source
+// size and fits within the given box. If the source is larger than the box,
+// then the largest size that is the source size divided by a whole number is
+// returned instead.
+func integerSize(src, box image.Point) image.Point {
+	if src.X <= 0 || src.Y <= 0 || box.X <= 0 || box.Y <= 0 {
+		return image.Point{}
+	}
+
+	scale := box.X / src.X
+	if s := box.Y / src.Y; s < scale {
+		scale = s
+	}
+
+	if scale >= 1 {
+		return src.Mul(scale)
+	}
+
+	div := ceilDiv(src.X, box.X)
+	if d := ceilDiv(src.Y, box.Y); d > div {
+		div = d
+	}
+
+	return src.Div(div)
+}
+
+// integerCenter returns the offset in pixels that centers an image of the given
+// size within the box, split into whole cells and the remaining pixels.
+func integerCenter(cell, size, box image.Point) (cells, pixels image.Point) {
+	if cell.X <= 0 || cell.Y <= 0 {
+		return image.Point{}, image.Point{}
+	}
+
+	center := box.Sub(size).Div(2)
+	if center.X < 0 {
+		center.X = 0
+	}
+	if center.Y < 0 {
+		center.Y = 0
+	}
+
+	cells = image.Pt(center.X/cell.X, center.Y/cell.Y)
+	pixels = image.Pt(center.X%cell.X, center.Y%cell.Y)
+
+	return cells, pixels
+}
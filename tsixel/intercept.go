@@ -0,0 +1,58 @@
+package tsixel
+
+import "github.com/gdamore/tcell/v2"
+
+// WrapDrawIntercept adds draw intercepts that run in a deterministic order
+// relative to the screen's own intercepts. Applications should use this
+// instead of adding intercepts onto the tcell screen directly, since the order
+// of those depends on when they were added.
+//
+// The before intercept runs before the images are updated, so cells that it
+// draws are accounted for by damage tracking. The after intercept runs after
+// the images are drawn, so anything that it draws directly goes over them.
+// Intercepts run in the order that they were added, and either may be nil.
+func (s *Screen) WrapDrawIntercept(before, after tcell.DrawInterceptFunc) {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	if before != nil {
+		s.intercepts = append(s.intercepts, before)
+	}
+	if after != nil {
+		s.interceptsAfter = append(s.interceptsAfter, after)
+	}
+}
+
+// intercept runs the before intercepts and then draws the images.
+func (s *Screen) intercept(screen tcell.Screen, sync bool) bool {
+	clear := runIntercepts(s.intercepts, screen, sync)
+
+	if s.beforeDraw(screen, sync) {
+		clear = true
+	}
+
+	return clear
+}
+
+// interceptAfter draws the images and then runs the after intercepts.
+func (s *Screen) interceptAfter(screen tcell.Screen, sync bool) bool {
+	clear := s.afterDraw(screen, sync)
+
+	if runIntercepts(s.interceptsAfter, screen, sync) {
+		clear = true
+	}
+
+	return clear
+}
+
+// runIntercepts runs all intercepts in order. It returns true if any of them
+// asks for the screen to be cleared.
+func runIntercepts(fns []tcell.DrawInterceptFunc, screen tcell.Screen, sync bool) bool {
+	var clear bool
+	for _, fn := range fns {
+		if fn(screen, sync) {
+			clear = true
+		}
+	}
+	return clear
+}
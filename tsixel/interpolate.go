@@ -0,0 +1,60 @@
+package tsixel
+
+import (
+	"image"
+	"time"
+)
+
+// SetInterpolation sets whether the animation draws an intermediate frame
+// blended from each pair of consecutive frames halfway through each frame's
+// delay. This makes low frame rate animations look smoother at the cost of
+// encoding twice as many frames. It can be toggled at any time, and it will not
+// redraw.
+func (anim *Animation) SetInterpolation(interpolate bool) {
+	anim.l.Lock()
+	defer anim.l.Unlock()
+
+	anim.interpolate = interpolate
+}
+
+// frameSource returns the source image of the frame at the given index, which
+// may be an intermediate frame if it's past the number of GIF frames.
+func (anim *Animation) frameSource(ix int) image.Image {
+	n := len(anim.gif.Image)
+	if ix < n {
+		return anim.gif.Image[ix]
+	}
+
+	// The blending is done lazily as the image is read, so it happens in the
+	// resize pipeline. Frames may only cover part of the GIF, so they're
+	// blended where they're placed within it.
+	ix -= n
+	return &blendImage{
+		from: anim.gif.Image[ix],
+		to:   anim.gif.Image[(ix+1)%n],
+		t:    0.5,
+		rect: image.Rect(0, 0, anim.gif.Config.Width, anim.gif.Config.Height),
+	}
+}
+
+// shownFrame returns the index of the frame to be shown at the given time. If
+// interpolation is on and the current frame is past half of its delay, then
+// the index of its intermediate frame is returned, which is offset by the
+// number of GIF frames.
+func (anim *Animation) shownFrame(now time.Time) int {
+	n := len(anim.gif.Image)
+	if !anim.interpolate || n < 2 {
+		return anim.frameIx
+	}
+
+	// Don't blend onto the first frame if the animation won't loop again.
+	if anim.frameIx == n-1 && anim.gif.LoopCount != 0 && anim.loopedN >= anim.gif.LoopCount {
+		return anim.frameIx
+	}
+
+	if now.Sub(anim.lastTime) < anim.frameDelay(anim.frameIx)/2 {
+		return anim.frameIx
+	}
+
+	return anim.frameIx + n
+}
@@ -0,0 +1,39 @@
+package tsixel
+
+import "sync/atomic"
+
+// Logger receives debug events from screens and resize pipelines, such as
+// redraw decisions, invalidations, resizing jobs and the number of bytes drawn.
+// It is useful for diagnosing flickering and redraw storms. Debugf may be
+// called from multiple goroutines, sometimes with the screen locked, so it must
+// not call back into the screen.
+type Logger interface {
+	Debugf(format string, args ...interface{})
+}
+
+// LoggerFunc is a function that implements Logger, such as log.Printf.
+type LoggerFunc func(format string, args ...interface{})
+
+// Debugf calls f. It implements Logger.
+func (f LoggerFunc) Debugf(format string, args ...interface{}) {
+	f(format, args...)
+}
+
+// loggerBox boxes a Logger, since atomic.Value requires the same concrete type.
+type loggerBox struct{ Logger }
+
+var logger atomic.Value // loggerBox
+
+// SetLogger sets the logger that debug events are sent to. A nil logger, which
+// is the default, disables logging.
+func SetLogger(l Logger) {
+	logger.Store(loggerBox{l})
+}
+
+// debugf sends a debug event to the logger if there is one.
+func debugf(format string, args ...interface{}) {
+	box, _ := logger.Load().(loggerBox)
+	if box.Logger != nil {
+		box.Logger.Debugf("tsixel: "+format, args...)
+	}
+}
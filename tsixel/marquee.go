@@ -0,0 +1,163 @@
+package tsixel
+
+import (
+	"image"
+	"sync"
+	"time"
+
+	"golang.org/x/image/draw"
+)
+
+// marqueeInterval is the interval between each step of a scrolling marquee.
+const marqueeInterval = time.Second / 15
+
+// Marquee is an Imager that scrolls an image wider than its box horizontally
+// in a loop, like a ticker. The image is scaled once to the height of the box,
+// and only the visible part of it is clipped and encoded on each step. If an
+// encode takes longer than a step, then the marquee skips to the latest step
+// once the encode is done.
+type Marquee struct {
+	l   sync.Mutex
+	img *Image
+
+	src    image.Image
+	scaler draw.Scaler
+	scaled *image.RGBA // src scaled to the box height, wrapped for the box width
+	width  int         // width of the scaled src without the wrapped part
+	view   image.Point // box size in pixels that scaled is made for
+
+	size   image.Point // box size in cells
+	speed  float64     // in cells per second
+	start  time.Time
+	offset int // last drawn offset in pixels
+	waker  waker
+}
+
+var _ GeometryImager = (*Marquee)(nil)
+
+// NewMarquee creates a new marquee from the given image. The Scaler option is
+// used to scale the image to the height of the box; if it is nil, then
+// draw.ApproxBiLinear is used. KeepRatio and Crossfade are ignored.
+func NewMarquee(src image.Image, opts ImageOpts) *Marquee {
+	scaler := opts.Scaler
+	if scaler == nil {
+		scaler = draw.ApproxBiLinear
+	}
+
+	// The image is already scaled, so the visible part is only clipped.
+	opts.Scaler = nil
+	opts.KeepRatio = false
+	opts.Crossfade = 0
+
+	return &Marquee{
+		img:    NewImage(image.NewRGBA(image.Rectangle{}), opts),
+		src:    src,
+		scaler: scaler,
+		offset: -1,
+	}
+}
+
+// SetSpeed sets the scrolling speed in cells per second. A negative speed
+// scrolls the image to the right instead. A zero speed stops scrolling. This
+// method will not redraw.
+func (m *Marquee) SetSpeed(cellsPerSecond float64) {
+	m.l.Lock()
+	defer m.l.Unlock()
+
+	m.speed = cellsPerSecond
+	m.start = time.Time{}
+}
+
+// SetPosition sets the top-left corner of the marquee in units of cells.
+func (m *Marquee) SetPosition(pos image.Point) {
+	m.img.SetPosition(pos)
+}
+
+// SetSize sets the size of the marquee's box in units of cells. The image is
+// scaled to fit the height of the box.
+func (m *Marquee) SetSize(size image.Point) {
+	m.l.Lock()
+	defer m.l.Unlock()
+
+	m.size = size
+	m.img.SetSize(size)
+}
+
+// Geometry returns the geometry of the marquee. It implements GeometryImager.
+func (m *Marquee) Geometry() Geometry {
+	return m.img.Geometry()
+}
+
+// Update scrolls the image and updates the visible part of it. It implements
+// Imager.
+func (m *Marquee) Update(state DrawState) Frame {
+	m.l.Lock()
+	defer m.l.Unlock()
+
+	view := state.PtInPixels(m.size)
+	if view.X <= 0 || view.Y <= 0 {
+		return m.img.Update(state)
+	}
+
+	if m.scaled == nil || m.view != view {
+		m.view = view
+		m.scaled, m.width = m.scaleSrc(view)
+		m.offset = -1
+		m.img.setSource(m.scaled)
+	}
+
+	if m.start.IsZero() {
+		m.start = state.Time
+	}
+
+	var offset int
+	width := m.width
+
+	if width > view.X && m.speed != 0 {
+		px := state.Time.Sub(m.start).Seconds() * m.speed * float64(state.CellSize().X)
+		offset = int(px) % width
+		if offset < 0 {
+			offset += width
+		}
+
+		m.waker.wakeAfter(&m.l, marqueeInterval, state.Delegate)
+	}
+
+	if offset != m.offset {
+		m.offset = offset
+		m.img.setScroll(image.Pt(offset, 0))
+	}
+
+	return m.img.Update(state)
+}
+
+// scaleSrc scales the source image to the height of the given view while
+// keeping its aspect ratio. If the scaled image is wider than the view, then
+// its start is repeated past its end, so that any viewport into it can be
+// clipped without wrapping around. The width of the scaled image without the
+// repeated part is also returned.
+func (m *Marquee) scaleSrc(view image.Point) (*image.RGBA, int) {
+	srcSize := m.src.Bounds().Size()
+	if srcSize.Y == 0 {
+		return image.NewRGBA(image.Rectangle{}), 0
+	}
+
+	width := srcSize.X * view.Y / srcSize.Y
+	rect := image.Rect(0, 0, width, view.Y)
+
+	// Draw the image as-is if it already fits.
+	if width <= view.X {
+		dst := image.NewRGBA(rect)
+		m.scaler.Scale(dst, dst.Rect, m.src, m.src.Bounds(), draw.Src, nil)
+		return dst, width
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width+view.X, view.Y))
+	m.scaler.Scale(dst, rect, m.src, m.src.Bounds(), draw.Src, nil)
+
+	// Repeat the start of the image for the viewport to wrap around into.
+	tail := image.Rect(width, 0, width+view.X, view.Y)
+	draw.Draw(dst, tail, dst, image.Point{}, draw.Src)
+
+	return dst, width
+}
@@ -0,0 +1,363 @@
+package tsixel
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"sync"
+	"time"
+
+	"golang.org/x/image/draw"
+)
+
+// Mosaic is an Imager that composites many small images, such as emojis or
+// icons, into a single SIXEL with one shared palette. Terminals only have a
+// limited number of color registers, so drawing each small image with its own
+// palette quickly overflows them; a mosaic uses a single palette and a single
+// escape sequence instead. The gaps between tiles are left transparent, so the
+// cells under them stay visible.
+type Mosaic struct {
+	l    sync.Mutex
+	opts ImageOpts
+
+	pos   image.Point // in cells
+	size  image.Point // in cells
+	tiles []*MosaicTile
+	dirty bool // true if the tiles changed since the last composite
+
+	buf     []byte
+	pixels  image.Point // composite size in pixels
+	cell    image.Point // cell size of the composite
+	origin  image.Point // offset in cells of the composite's visible part
+	bounds  image.Rectangle
+	err     error
+	encTime time.Duration
+	updated bool
+	gen     uint64 // generation of the latest job
+}
+
+var _ GeometryImager = (*Mosaic)(nil)
+
+// MosaicTile is a single image within a Mosaic.
+type MosaicTile struct {
+	mosaic *Mosaic
+	src    image.Image
+	rect   image.Rectangle // relative to the mosaic, in cells
+}
+
+// NewMosaic creates a new empty mosaic. The Scaler option is used to scale
+// each tile into its region; if it is nil, then draw.ApproxBiLinear is used.
+// If KeepRatio is true, then each tile keeps its aspect ratio. The color
+// options apply to the shared palette. Overflow, EdgeMargin and Crossfade are
+// ignored.
+func NewMosaic(opts ImageOpts) *Mosaic {
+	if opts.Scaler == nil {
+		opts.Scaler = draw.ApproxBiLinear
+	}
+
+	return &Mosaic{opts: opts}
+}
+
+// AddTile adds an image onto the mosaic within the given region in units of
+// cells relative to the mosaic. Tiles added later are drawn over earlier ones.
+// This method will not redraw.
+func (m *Mosaic) AddTile(src image.Image, rect image.Rectangle) *MosaicTile {
+	m.l.Lock()
+	defer m.l.Unlock()
+
+	tile := &MosaicTile{mosaic: m, src: src, rect: rect}
+	m.tiles = append(m.tiles, tile)
+	m.dirty = true
+
+	return tile
+}
+
+// Clear removes all tiles from the mosaic. This method will not redraw.
+func (m *Mosaic) Clear() {
+	m.l.Lock()
+	defer m.l.Unlock()
+
+	m.tiles = nil
+	m.dirty = true
+}
+
+// SetPosition sets the top-left corner of the mosaic in units of cells. This
+// method will not redraw.
+func (m *Mosaic) SetPosition(pos image.Point) {
+	m.l.Lock()
+	defer m.l.Unlock()
+
+	m.pos = pos
+}
+
+// SetSize sets the size of the mosaic in units of cells. Tiles outside of it
+// are cut off. This method will not redraw.
+func (m *Mosaic) SetSize(size image.Point) {
+	m.l.Lock()
+	defer m.l.Unlock()
+
+	m.size = size
+}
+
+// Geometry returns the geometry of the mosaic. It implements GeometryImager.
+func (m *Mosaic) Geometry() Geometry {
+	m.l.Lock()
+	defer m.l.Unlock()
+
+	return Geometry{
+		Requested: image.Rectangle{Min: m.pos, Max: m.pos.Add(m.size)},
+		Cells:     m.bounds,
+		Pixels:    m.pixels,
+	}
+}
+
+// SetImage replaces the tile's image. This method will not redraw.
+func (tile *MosaicTile) SetImage(src image.Image) {
+	tile.mosaic.l.Lock()
+	defer tile.mosaic.l.Unlock()
+
+	tile.src = src
+	tile.mosaic.dirty = true
+}
+
+// SetRect sets the tile's region in units of cells relative to the mosaic.
+// This method will not redraw.
+func (tile *MosaicTile) SetRect(rect image.Rectangle) {
+	tile.mosaic.l.Lock()
+	defer tile.mosaic.l.Unlock()
+
+	tile.rect = rect
+	tile.mosaic.dirty = true
+}
+
+// Remove removes the tile from its mosaic. This method will not redraw.
+func (tile *MosaicTile) Remove() {
+	m := tile.mosaic
+
+	m.l.Lock()
+	defer m.l.Unlock()
+
+	for i, t := range m.tiles {
+		if t == tile {
+			m.tiles = append(m.tiles[:i], m.tiles[i+1:]...)
+			m.dirty = true
+			return
+		}
+	}
+}
+
+// Update composites the tiles and encodes them in the resize pipeline if they
+// changed. It implements Imager.
+func (m *Mosaic) Update(state DrawState) Frame {
+	m.l.Lock()
+	defer m.l.Unlock()
+
+	if state.IsEmpty() {
+		return Frame{}
+	}
+
+	// Cut off the part of the mosaic that is outside the screen.
+	region := image.Rectangle{Min: m.pos, Max: m.pos.Add(m.size)}
+	region = region.Intersect(image.Rectangle{Max: state.Cells})
+
+	pixels := state.PtInPixels(region.Size())
+	if !m.opts.NoRounding {
+		pixels.Y -= pixels.Y % SIXELHeight
+	}
+
+	cell := state.CellSize()
+	origin := region.Min.Sub(m.pos)
+
+	if m.dirty || pixels != m.pixels || cell != m.cell || origin != m.origin {
+		m.dirty = false
+		m.pixels = pixels
+		m.cell = cell
+		m.origin = origin
+		m.encode(state)
+	}
+
+	m.bounds = image.Rectangle{
+		Min: region.Min,
+		Max: region.Min.Add(state.PtInCells(m.pixels)),
+	}
+
+	frame := Frame{
+		SIXEL:      m.buf,
+		Bounds:     m.bounds,
+		MustUpdate: m.updated || state.Sync,
+		Err:        m.err,
+		EncodeTime: m.encTime,
+		Redraw:     m.opts.Redraw,
+	}
+
+	m.updated = false
+	return frame
+}
+
+// encode takes a snapshot of the tiles to be composited and encoded.
+func (m *Mosaic) encode(state DrawState) {
+	m.gen++
+
+	if m.pixels.X <= 0 || m.pixels.Y <= 0 || len(m.tiles) == 0 {
+		m.buf = nil
+		m.err = nil
+		m.updated = true
+		return
+	}
+
+	// The tiles are only composited once the job is run.
+	src := m.snapshot()
+
+	// The composite is already in the right size.
+	opts := m.opts
+	opts.Scaler = nil
+	opts.KeepRatio = false
+	opts.transparent = true
+
+	if state.Offline {
+		start := time.Now()
+
+		m.buf, _, m.err = resizerMain.pool.do(src, m.pixels, opts)
+		m.encTime = time.Since(start)
+		m.updated = true
+
+		return
+	}
+
+	resizerMain.QueueJob(ResizerJob{
+		SrcImg:     src,
+		Options:    opts,
+		NewSize:    m.pixels,
+		Generation: m.gen,
+
+		Priority: m.opts.Priority,
+		Owner:    m,
+
+		Superseded: func(job ResizerJob) bool {
+			m.l.Lock()
+			defer m.l.Unlock()
+
+			return job.Generation != m.gen
+		},
+
+		Done: func(job ResizerJob, out []byte, err error) {
+			m.l.Lock()
+
+			if job.Generation != m.gen {
+				m.l.Unlock()
+				return
+			}
+
+			// Composite again on the next update if the job was dropped.
+			if errors.Is(err, ErrJobDropped) {
+				m.dirty = true
+				m.l.Unlock()
+				return
+			}
+
+			// Keep the old SIXEL on error; the screen will draw a placeholder
+			// over it instead.
+			if err == nil {
+				m.buf = out
+			}
+			m.err = err
+			m.encTime = job.Elapsed
+			m.updated = true
+
+			m.l.Unlock()
+
+			state.Delegate()
+		},
+	})
+}
+
+// snapshot takes a snapshot of the tiles and their regions in pixels, which is
+// composited lazily.
+func (m *Mosaic) snapshot() *mosaicSnapshot {
+	snapshot := &mosaicSnapshot{
+		size:   m.pixels,
+		scaler: m.opts.Scaler,
+	}
+
+	bounds := image.Rectangle{Max: m.pixels}
+
+	for _, tile := range m.tiles {
+		rect := tile.rect.Sub(m.origin)
+		rect = image.Rectangle{
+			Min: image.Pt(rect.Min.X*m.cell.X, rect.Min.Y*m.cell.Y),
+			Max: image.Pt(rect.Max.X*m.cell.X, rect.Max.Y*m.cell.Y),
+		}
+
+		if m.opts.KeepRatio {
+			rect.Max = rect.Min.Add(fitSize(tile.src.Bounds().Size(), rect.Size()))
+		}
+
+		if rect.Empty() || !rect.Overlaps(bounds) {
+			continue
+		}
+
+		snapshot.tiles = append(snapshot.tiles, mosaicTileRect{tile.src, rect})
+	}
+
+	return snapshot
+}
+
+// mosaicSnapshot is a snapshot of a mosaic's tiles. The tiles are composited
+// only once the image is first read, so that it happens in the resize pipeline
+// instead of in Update.
+type mosaicSnapshot struct {
+	size   image.Point
+	tiles  []mosaicTileRect
+	scaler draw.Scaler
+
+	once sync.Once
+	img  *image.RGBA
+}
+
+// mosaicTileRect is a tile's image and its region in pixels.
+type mosaicTileRect struct {
+	src  image.Image
+	rect image.Rectangle
+}
+
+var _ lazyImage = (*mosaicSnapshot)(nil)
+
+func (snapshot *mosaicSnapshot) ColorModel() color.Model { return color.RGBAModel }
+
+func (snapshot *mosaicSnapshot) Bounds() image.Rectangle {
+	return image.Rectangle{Max: snapshot.size}
+}
+
+func (snapshot *mosaicSnapshot) At(x, y int) color.Color {
+	return snapshot.render().At(x, y)
+}
+
+// render composites all tiles onto a new transparent image of the mosaic's
+// size. The composite is only drawn once.
+func (snapshot *mosaicSnapshot) render() image.Image {
+	snapshot.once.Do(func() {
+		dst := image.NewRGBA(snapshot.Bounds())
+
+		for _, tile := range snapshot.tiles {
+			snapshot.scaler.Scale(dst, tile.rect, tile.src, tile.src.Bounds(), draw.Over, nil)
+		}
+
+		snapshot.img = dst
+	})
+
+	return snapshot.img
+}
+
+// fitSize returns the largest size with the aspect ratio of size that fits
+// within max. Unlike maxSize, the size may be scaled up.
+func fitSize(size, max image.Point) image.Point {
+	if size.X <= 0 || size.Y <= 0 {
+		return image.Point{}
+	}
+
+	if size.X*max.Y > size.Y*max.X {
+		return image.Pt(max.X, size.Y*max.X/size.X)
+	}
+
+	return image.Pt(size.X*max.Y/size.Y, max.Y)
+}
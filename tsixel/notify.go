@@ -0,0 +1,85 @@
+package tsixel
+
+import (
+	"image"
+	"sync"
+)
+
+// BoundsChangedFunc is the callback type for OnImageBoundsChanged.
+type BoundsChangedFunc func(img Imager, old, new image.Rectangle)
+
+// boundsChange is a pending bounds change notification.
+type boundsChange struct {
+	img      Imager
+	old, new image.Rectangle
+}
+
+// OnImageBoundsChanged sets the callback to be called when an image's drawn
+// bounds change, such as when the terminal is resized or when the rounding
+// changes. It is useful for reflowing text around images without polling.
+//
+// The callback is called in a separate goroutine after the draw, so it may use
+// the screen. Changes are delivered in the order that they're drawn, including
+// across draws. Giving a nil callback removes it.
+func (s *Screen) OnImageBoundsChanged(f BoundsChangedFunc) {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	s.onBounds = f
+}
+
+// notifyBounds dispatches the given bounds changes to the callback.
+func (s *Screen) notifyBounds(changes []boundsChange) {
+	if len(changes) == 0 || s.onBounds == nil {
+		return
+	}
+
+	s.notifier.push(s.onBounds, changes)
+}
+
+// boundsNotifier delivers bounds changes to their callbacks in the order that
+// they're pushed. At most one goroutine delivers them at a time; it exits once
+// there are no more changes.
+type boundsNotifier struct {
+	mu      sync.Mutex
+	queue   []boundsNotification
+	running bool
+}
+
+// boundsNotification is a batch of bounds changes from the same draw.
+type boundsNotification struct {
+	f       BoundsChangedFunc
+	changes []boundsChange
+}
+
+func (n *boundsNotifier) push(f BoundsChangedFunc, changes []boundsChange) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.queue = append(n.queue, boundsNotification{f, changes})
+
+	if !n.running {
+		n.running = true
+		go n.deliver()
+	}
+}
+
+func (n *boundsNotifier) deliver() {
+	for {
+		n.mu.Lock()
+		queue := n.queue
+		n.queue = nil
+		n.running = len(queue) > 0
+		n.mu.Unlock()
+
+		if len(queue) == 0 {
+			return
+		}
+
+		for _, notif := range queue {
+			for _, change := range notif.changes {
+				notif.f(change.img, change.old, change.new)
+			}
+		}
+	}
+}
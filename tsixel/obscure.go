@@ -0,0 +1,164 @@
+package tsixel
+
+import (
+	"image"
+	"sync"
+
+	"golang.org/x/image/draw"
+)
+
+// BlurMode is the mode used to obscure an image.
+type BlurMode uint8
+
+const (
+	// BlurNone does not obscure the image.
+	BlurNone BlurMode = iota
+	// BlurPixelate renders the image as large blocks of colors.
+	BlurPixelate
+	// BlurSmooth renders the image heavily blurred.
+	BlurSmooth
+)
+
+// obscureBlocks is the number of blocks on the longer side of an obscured
+// image.
+const obscureBlocks = 12
+
+// obscureImage obscures the given image in place.
+func obscureImage(dst *image.RGBA, mode BlurMode) {
+	if mode == BlurNone || dst.Rect.Empty() {
+		return
+	}
+
+	small := maxSize(dst.Rect.Size(), image.Pt(obscureBlocks, obscureBlocks))
+	if small.X < 1 {
+		small.X = 1
+	}
+	if small.Y < 1 {
+		small.Y = 1
+	}
+
+	// Downscale the image to average out the details, then upscale it back.
+	tmp := image.NewRGBA(image.Rectangle{Max: small})
+	draw.BiLinear.Scale(tmp, tmp.Rect, dst, dst.Rect, draw.Src, nil)
+
+	var upscaler draw.Scaler = draw.NearestNeighbor
+	if mode == BlurSmooth {
+		upscaler = draw.BiLinear
+	}
+
+	upscaler.Scale(dst, dst.Rect, tmp, tmp.Rect, draw.Src, nil)
+}
+
+// obscurer is implemented by images that can be rendered obscured.
+type obscurer interface {
+	setObscured(mode BlurMode)
+}
+
+var (
+	_ obscurer = (*Image)(nil)
+	_ obscurer = (*Animation)(nil)
+)
+
+// ObscuredImage wraps an Imager to render an obscured version of it until
+// Reveal is called. It is useful for spoilers and sensitive content. Only
+// Image and Animation can be rendered obscured; other Imagers are not drawn
+// at all until revealed.
+type ObscuredImage struct {
+	Imager
+	l sync.Mutex
+
+	mode     BlurMode
+	revealed bool
+	changed  bool
+}
+
+var _ GeometryImager = (*ObscuredImage)(nil)
+
+// Obscured wraps the given image to be rendered obscured using the given mode.
+// The wrapper should be added onto the screen instead of the image.
+func Obscured(img Imager, mode BlurMode) *ObscuredImage {
+	if o, ok := img.(obscurer); ok {
+		o.setObscured(mode)
+	}
+
+	return &ObscuredImage{
+		Imager: img,
+		mode:   mode,
+	}
+}
+
+// Reveal reveals the image. The sharp image is encoded again on the next
+// draw. This method will not redraw.
+func (obscured *ObscuredImage) Reveal() {
+	obscured.setRevealed(true)
+}
+
+// Conceal obscures the image again after Reveal. This method will not redraw.
+func (obscured *ObscuredImage) Conceal() {
+	obscured.setRevealed(false)
+}
+
+// Revealed returns true if the image is revealed.
+func (obscured *ObscuredImage) Revealed() bool {
+	obscured.l.Lock()
+	defer obscured.l.Unlock()
+
+	return obscured.revealed
+}
+
+func (obscured *ObscuredImage) setRevealed(revealed bool) {
+	obscured.l.Lock()
+	defer obscured.l.Unlock()
+
+	if obscured.revealed == revealed {
+		return
+	}
+
+	obscured.revealed = revealed
+	obscured.changed = true
+
+	if o, ok := obscured.Imager.(obscurer); ok {
+		mode := obscured.mode
+		if revealed {
+			mode = BlurNone
+		}
+		o.setObscured(mode)
+	}
+}
+
+// Geometry returns the geometry of the wrapped image, or a zero geometry if
+// the wrapped image can't report it. It implements GeometryImager.
+func (obscured *ObscuredImage) Geometry() Geometry {
+	if geom, ok := obscured.Imager.(GeometryImager); ok {
+		return geom.Geometry()
+	}
+	return Geometry{}
+}
+
+// Update updates the wrapped image. It implements Imager.
+func (obscured *ObscuredImage) Update(state DrawState) Frame {
+	obscured.l.Lock()
+	defer obscured.l.Unlock()
+
+	frame := obscured.Imager.Update(state)
+
+	if obscured.changed {
+		obscured.changed = false
+		frame.MustUpdate = true
+	}
+
+	_, ok := obscured.Imager.(obscurer)
+
+	// Don't draw images that we can't obscure.
+	if !ok && !obscured.revealed {
+		frame.SIXEL = nil
+	}
+
+	// The sharp image may still be on the terminal while the obscured one is
+	// being encoded, so report no bounds to have the screen cleared.
+	if ok && !obscured.revealed && frame.SIXEL == nil && frame.Err == nil {
+		return Frame{}
+	}
+
+	return frame
+}
@@ -0,0 +1,102 @@
+package tsixel
+
+import (
+	"image"
+	"image/color"
+)
+
+// SetPixelOffset sets the offset of the image in pixels relative to its
+// position. The image is shifted by padding the SIXEL with transparent pixels,
+// which allows moving the image smoothly instead of in units of cells. The
+// offset is clamped to be less than one cell, and the image may occupy one more
+// cell on each axis because of it.
+func (img *imageState) SetPixelOffset(pt image.Point) {
+	img.l.Lock()
+	defer img.l.Unlock()
+
+	img.pxOffset = pt
+}
+
+// PixelOffset returns the offset set using SetPixelOffset.
+func (img *imageState) PixelOffset() image.Point {
+	img.l.Lock()
+	defer img.l.Unlock()
+
+	return img.pxOffset
+}
+
+// clampOffset clamps the offset to be within a single cell.
+func clampOffset(offset, cell image.Point) image.Point {
+	clamp := func(n, max int) int {
+		if n < 0 || max <= 0 {
+			return 0
+		}
+		if n >= max {
+			return max - 1
+		}
+		return n
+	}
+
+	return image.Point{
+		X: clamp(offset.X, cell.X),
+		Y: clamp(offset.Y, cell.Y),
+	}
+}
+
+// padPaletted pads the top and left of the paletted image by the given offset.
+// The padding uses a new transparent color, which the encoder skips.
+func padPaletted(src *image.Paletted, offset image.Point) *image.Paletted {
+	palette := make(color.Palette, len(src.Palette), len(src.Palette)+1)
+	copy(palette, src.Palette)
+	palette = append(palette, color.Transparent)
+
+	size := src.Rect.Size()
+
+	dst := image.NewPaletted(image.Rectangle{Max: size.Add(offset)}, palette)
+	transparent := uint8(len(palette) - 1)
+
+	for i := range dst.Pix {
+		dst.Pix[i] = transparent
+	}
+
+	for y := 0; y < size.Y; y++ {
+		copy(
+			dst.Pix[dst.PixOffset(offset.X, offset.Y+y):],
+			src.Pix[src.PixOffset(src.Rect.Min.X, src.Rect.Min.Y+y):src.PixOffset(src.Rect.Max.X, src.Rect.Min.Y+y)],
+		)
+	}
+
+	return dst
+}
+
+// maskTransparent maps the pixels that are transparent in the source image onto
+// a new transparent color, which the encoder skips. The source image must have
+// the same bounds as the paletted image.
+func maskTransparent(dst *image.Paletted, src *image.RGBA) *image.Paletted {
+	palette := make(color.Palette, len(dst.Palette), len(dst.Palette)+1)
+	copy(palette, dst.Palette)
+	palette = append(palette, color.Transparent)
+
+	dst.Palette = palette
+	transparent := uint8(len(palette) - 1)
+
+	for y := src.Rect.Min.Y; y < src.Rect.Max.Y; y++ {
+		for x := src.Rect.Min.X; x < src.Rect.Max.X; x++ {
+			if src.Pix[src.PixOffset(x, y)+3] < 0x80 {
+				dst.Pix[dst.PixOffset(x, y)] = transparent
+			}
+		}
+	}
+
+	return dst
+}
+
+// keepBackground changes the background select parameter of the SIXEL header
+// so that the terminal leaves pixels that aren't drawn unchanged instead of
+// filling them with the background color.
+func keepBackground(b []byte) {
+	// The encoder always writes the header as "\x1bP0;0;8q".
+	if len(b) > 4 && b[0] == '\x1b' && b[1] == 'P' && b[4] == '0' {
+		b[4] = '1'
+	}
+}
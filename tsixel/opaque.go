@@ -0,0 +1,42 @@
+package tsixel
+
+import "image"
+
+// opaqueSource returns true if the SIXEL encoded from the given source into
+// the given size has no transparent pixels. It returns false if unsure.
+func opaqueSource(src image.Image, size image.Point, opts ImageOpts) bool {
+	o, ok := src.(interface{ Opaque() bool })
+	if !ok {
+		return false
+	}
+
+	// Images that aren't scaled are clipped, so they might not fill the whole
+	// size.
+	srcSize := src.Bounds().Size()
+	if opts.Scaler == nil && (srcSize.X < size.X || srcSize.Y < size.Y) {
+		return false
+	}
+
+	return o.Opaque()
+}
+
+// coveredCells returns the cells relative to the top-left corner of the image
+// that are fully covered by a SIXEL of the given size in pixels.
+func coveredCells(cell, size image.Point, opts ImageOpts) image.Rectangle {
+	if cell.X == 0 || cell.Y == 0 {
+		return image.Rectangle{}
+	}
+
+	if opts.Overflow == OverflowClip {
+		size = opts.clip
+	}
+
+	// The offset pads the top-left with transparent pixels, so the partially
+	// covered cells there are skipped.
+	max := opts.offset.Add(size)
+
+	return image.Rectangle{
+		Min: ptInCells(cell, opts.offset),
+		Max: image.Pt(max.X/cell.X, max.Y/cell.Y),
+	}
+}
@@ -0,0 +1,85 @@
+package tsixel
+
+import "image"
+
+// Overflow is the policy of an image that doesn't fit within the screen.
+type Overflow uint8
+
+const (
+	// OverflowShrink shrinks the image to fit within the screen, minus the
+	// edge margin. It is the default policy.
+	OverflowShrink Overflow = iota
+	// OverflowClip keeps the image's size and crops off the part that is
+	// outside the screen, minus the edge margin.
+	OverflowClip
+	// OverflowAllow keeps the image's size and draws it entirely, leaving the
+	// terminal to clip it. Note that some terminals scroll if an image goes
+	// past the bottom of the screen.
+	OverflowAllow
+)
+
+// DefaultEdgeMargin is the default edge margin in cells. Images are kept away
+// from the right and bottom edges of the screen by this margin to prevent
+// terminals from wrapping or scrolling when an image touches the edge.
+var DefaultEdgeMargin = image.Pt(4, 2)
+
+// NoEdgeMargin is the edge margin that lets images touch the right and bottom
+// edges of the screen.
+var NoEdgeMargin = image.Pt(-1, -1)
+
+// edgeMargin returns the margin in cells from the right and bottom edges of
+// the screen. It is zero if NoRounding is true, since the sizes are then
+// expected to be calculated manually.
+func (opts ImageOpts) edgeMargin() image.Point {
+	if opts.NoRounding {
+		return image.Point{}
+	}
+
+	margin := opts.EdgeMargin
+	if margin == (image.Point{}) {
+		margin = DefaultEdgeMargin
+	}
+
+	if margin.X < 0 {
+		margin.X = 0
+	}
+	if margin.Y < 0 {
+		margin.Y = 0
+	}
+
+	return margin
+}
+
+// screenBounds returns the region of the screen that the image may be drawn in
+// in units of cells.
+func (img *imageState) screenBounds() image.Rectangle {
+	return image.Rectangle{
+		Max: img.sstate.Cells.Sub(img.opts.edgeMargin()),
+	}
+}
+
+// clipSize returns the size in pixels of the visible part of the image with
+// the given region in pixels. The image is only cropped from the right and the
+// bottom. A zero point is returned if the Overflow policy isn't OverflowClip or
+// if no part of the image is visible; otherwise, the size is returned even if
+// the whole image is visible.
+func (img *imageState) clipSize(state DrawState, rectPx image.Rectangle) image.Point {
+	if img.opts.Overflow != OverflowClip {
+		return image.Point{}
+	}
+
+	visible := rectPx.Intersect(state.RectInPixels(img.screenBounds(), false))
+	if visible.Empty() {
+		return image.Point{}
+	}
+
+	clip := visible.Max.Sub(rectPx.Min)
+
+	// Round the height down so that the last SIXEL strip doesn't go past the
+	// visible region.
+	if !img.opts.NoRounding && clip.Y < rectPx.Dy() {
+		clip.Y -= clip.Y % SIXELHeight
+	}
+
+	return clip
+}
@@ -0,0 +1,180 @@
+package tsixel
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image/color"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// DefaultGrayLevels is the number of gray levels used for Grayscale images if
+// ImageOpts' Colors is not set.
+const DefaultGrayLevels = 16
+
+// monochromePalette is the palette used for Monochrome images.
+var monochromePalette = color.Palette{
+	color.Gray{Y: 0x00},
+	color.Gray{Y: 0xFF},
+}
+
+// grayPalette creates a palette of evenly spaced gray levels.
+func grayPalette(levels int) color.Palette {
+	if levels < 2 {
+		levels = 2
+	}
+
+	palette := make(color.Palette, levels)
+	for i := range palette {
+		palette[i] = color.Gray{Y: uint8(i * 0xFF / (levels - 1))}
+	}
+
+	return palette
+}
+
+// maxPaletteColors is the maximum number of colors in a fixed palette. The
+// rest are reserved for transparency.
+const maxPaletteColors = 254
+
+// ErrInvalidPalette is returned if a palette file cannot be parsed.
+var ErrInvalidPalette = errors.New("invalid palette")
+
+// LoadPaletteCSV reads a palette from the given CSV data. Each line is a color
+// of either 3 or 4 comma-separated components from 0 to 255 in RGB(A) order,
+// or a single hexadecimal color like #FF8000. Empty lines and lines starting
+// with a semicolon are ignored.
+func LoadPaletteCSV(r io.Reader) (color.Palette, error) {
+	var palette color.Palette
+
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, ";") {
+			continue
+		}
+
+		c, err := parseCSVColor(text)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+
+		palette = append(palette, c)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(palette) == 0 {
+		return nil, fmt.Errorf("%w: no colors", ErrInvalidPalette)
+	}
+
+	return palette, nil
+}
+
+func parseCSVColor(text string) (color.NRGBA, error) {
+	fields := strings.Split(text, ",")
+	for i := range fields {
+		fields[i] = strings.TrimSpace(fields[i])
+	}
+
+	if len(fields) == 1 && strings.HasPrefix(fields[0], "#") {
+		hex := fields[0][1:]
+		if len(hex) != 6 {
+			return color.NRGBA{}, fmt.Errorf("%w: bad hex color %q", ErrInvalidPalette, fields[0])
+		}
+
+		v, err := strconv.ParseUint(hex, 16, 32)
+		if err != nil {
+			return color.NRGBA{}, fmt.Errorf("%w: bad hex color %q", ErrInvalidPalette, fields[0])
+		}
+
+		return color.NRGBA{R: uint8(v >> 16), G: uint8(v >> 8), B: uint8(v), A: 0xFF}, nil
+	}
+
+	if len(fields) != 3 && len(fields) != 4 {
+		return color.NRGBA{}, fmt.Errorf("%w: expected 3 or 4 components, got %d", ErrInvalidPalette, len(fields))
+	}
+
+	rgba := [4]uint8{3: 0xFF}
+	for i, field := range fields {
+		v, err := strconv.ParseUint(field, 10, 8)
+		if err != nil {
+			return color.NRGBA{}, fmt.Errorf("%w: bad component %q", ErrInvalidPalette, field)
+		}
+		rgba[i] = uint8(v)
+	}
+
+	return color.NRGBA{R: rgba[0], G: rgba[1], B: rgba[2], A: rgba[3]}, nil
+}
+
+// LoadPaletteACT reads a palette from the given Adobe Color Table data. The
+// data is 256 RGB triplets, optionally followed by the number of colors used
+// and the index of the transparent color. The transparent color is skipped.
+func LoadPaletteACT(r io.Reader) (color.Palette, error) {
+	var data [256 * 3]byte
+	if _, err := io.ReadFull(r, data[:]); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPalette, err)
+	}
+
+	count := 256
+	transparent := -1
+
+	var trailer [4]byte
+	switch _, err := io.ReadFull(r, trailer[:]); {
+	case err == nil:
+		count = int(binary.BigEndian.Uint16(trailer[0:2]))
+		if count == 0 || count > 256 {
+			count = 256
+		}
+		if ix := binary.BigEndian.Uint16(trailer[2:4]); ix != 0xFFFF {
+			transparent = int(ix)
+		}
+	case err != io.EOF:
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPalette, err)
+	}
+
+	palette := make(color.Palette, 0, count)
+	for i := 0; i < count; i++ {
+		if i == transparent {
+			continue
+		}
+
+		rgb := data[i*3 : i*3+3]
+		palette = append(palette, color.NRGBA{R: rgb[0], G: rgb[1], B: rgb[2], A: 0xFF})
+	}
+
+	return palette, nil
+}
+
+// fixedPalette returns the fixed palette chosen by the options, or nil if the
+// image should be quantized instead.
+func (opts ImageOpts) fixedPalette() color.Palette {
+	switch {
+	case len(opts.Palette) > 0:
+		if len(opts.Palette) > maxPaletteColors {
+			return opts.Palette[:maxPaletteColors]
+		}
+		return opts.Palette
+	case opts.Monochrome:
+		return monochromePalette
+	case opts.Grayscale:
+		// Quality presets set their own color counts, so ignore those.
+		if opts.Colors == 0 || opts.Quality != QualityCustom {
+			return grayPalette(DefaultGrayLevels)
+		}
+
+		// Reserve a color for transparency.
+		levels := opts.Colors - 1
+		if levels > 254 {
+			levels = 254
+		}
+
+		return grayPalette(levels)
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,106 @@
+package tsixel
+
+import "time"
+
+// Pause freezes the screen's clock and halts the main resize pipeline. While
+// paused, animations stay on their current frame, and queued resizing jobs are
+// held until Resume is called. Since the pipeline is shared, jobs of other
+// screens are held too until every screen holding it is resumed. Pause does
+// nothing if the screen is already paused.
+func (s *Screen) Pause() {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	s.pause()
+}
+
+func (s *Screen) pause() {
+	if s.paused {
+		return
+	}
+
+	s.paused = true
+	s.pausedAt = time.Now()
+	s.holdPipeline()
+}
+
+// Resume resumes the screen after Pause or Suspend. If the underlying tcell
+// screen was suspended using Suspend, then it is also resumed, and all images
+// are refreshed, since the terminal might have dropped them. Animations
+// continue from the frame they were paused on.
+func (s *Screen) Resume() error {
+	s.l.Lock()
+	suspended := s.suspended
+	s.suspended = false
+	s.unpause()
+	s.l.Unlock()
+
+	if !suspended {
+		return nil
+	}
+
+	if err := s.s.Resume(); err != nil {
+		return err
+	}
+
+	s.Refresh()
+	return nil
+}
+
+func (s *Screen) unpause() {
+	if !s.paused {
+		return
+	}
+
+	s.paused = false
+	s.pausedFor += time.Since(s.pausedAt)
+	s.holdPipeline()
+}
+
+// holdPipeline holds the main resize pipeline while the screen is paused or its
+// graphics are disabled, and releases it otherwise. Each screen holds the
+// pipeline at most once, so screens don't release each other's holds.
+func (s *Screen) holdPipeline() {
+	hold := s.paused || s.noGraphics
+	if hold == s.holding {
+		return
+	}
+
+	s.holding = hold
+
+	if hold {
+		resizerMain.Pause()
+	} else {
+		resizerMain.Resume()
+	}
+}
+
+// Suspend pauses the screen and suspends the underlying tcell screen. It is
+// useful for shelling out to another program, such as $EDITOR. Call Resume to
+// restore the screen.
+func (s *Screen) Suspend() error {
+	s.l.Lock()
+	s.suspended = true
+	s.pause()
+	s.l.Unlock()
+
+	if err := s.s.Suspend(); err != nil {
+		s.l.Lock()
+		s.suspended = false
+		s.unpause()
+		s.l.Unlock()
+
+		return err
+	}
+
+	return nil
+}
+
+// now returns the current time on the screen's clock. The clock does not
+// advance while the screen is paused.
+func (s *Screen) now() time.Time {
+	if s.paused {
+		return s.pausedAt.Add(-s.pausedFor)
+	}
+	return time.Now().Add(-s.pausedFor)
+}
@@ -0,0 +1,76 @@
+package tsixel
+
+import (
+	"errors"
+	"image"
+	"io"
+)
+
+// ErrInvalidCellSize is returned by Fprint if the given cell size is not
+// positive.
+var ErrInvalidCellSize = errors.New("invalid cell size")
+
+// Fprint scales the given image to fit within targetCells and writes it as a
+// self-contained SIXEL to w. It applies the same rounding and aspect ratio
+// logic as Image, except the image is not constrained by any screen. The size
+// of each cell in pixels must be given. Fprint is useful for non-interactive
+// programs that do not initialize a tcell screen.
+func Fprint(w io.Writer, img image.Image, opts ImageOpts, targetCells, cellSize image.Point) error {
+	if cellSize.X <= 0 || cellSize.Y <= 0 {
+		return ErrInvalidCellSize
+	}
+
+	if targetCells.X <= 0 || targetCells.Y <= 0 {
+		return nil
+	}
+
+	state := NewDrawState(targetCells, image.Point{
+		X: targetCells.X * cellSize.X,
+		Y: targetCells.Y * cellSize.Y,
+	})
+
+	// There is no screen to overflow.
+	opts.Overflow = OverflowShrink
+
+	rect := fitRect(state, image.Rectangle{Max: targetCells}, img.Bounds().Size(), opts)
+	if rect.Empty() {
+		return nil
+	}
+
+	b, _, err := resizerMain.pool.do(img, rect.Size(), opts)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(b)
+	return err
+}
+
+// EncodeTo synchronously encodes the image for its current geometry on the
+// given state and writes the SIXEL to w, bypassing the screen and the resize
+// pipeline. The image's own state is left untouched. Nothing is written if the
+// image has no size. The SIXEL does not include any cursor positioning, so it
+// is drawn wherever the cursor is. It is useful for preparing SIXELs for
+// another process or for writing .six files.
+func (img *Image) EncodeTo(w io.Writer, state DrawState) error {
+	var geom imageState
+	img.cloneInto(&geom)
+
+	img.l.Lock()
+	src := img.src
+	img.l.Unlock()
+
+	geom.updateSize(state)
+	if geom.imgPixels.X <= 0 || geom.imgPixels.Y <= 0 {
+		return nil
+	}
+
+	enc, _, err := resizerMain.pool.encode(src, geom.imgPixels, geom.opts)
+	if err != nil {
+		return err
+	}
+	defer resizerMain.pool.put(enc)
+
+	_, err = w.Write(enc.buf.Bytes())
+	return err
+}
@@ -0,0 +1,132 @@
+package tsixel
+
+import (
+	"image"
+	"image/color"
+
+	"github.com/ericpauley/go-quantize/quantize"
+	"golang.org/x/image/draw"
+)
+
+// Quality is a preset that jointly tunes the scaler, the color count, the
+// dithering and the color quantizer of an image.
+type Quality uint8
+
+const (
+	// QualityCustom uses the individual fields in ImageOpts as-is. It is the
+	// zero-value.
+	QualityCustom Quality = iota
+	// QualityFast favors encoding speed: it uses an approximate bilinear
+	// scaler, 64 colors and no dithering.
+	QualityFast
+	// QualityBalanced uses a bilinear scaler, 128 colors and dithering with
+	// mean-aggregated colors.
+	QualityBalanced
+	// QualityBest favors fidelity: it uses a Catmull-Rom scaler, 255 colors
+	// and dithering with mean-aggregated colors.
+	QualityBest
+)
+
+// qualityPreset describes the knobs that a Quality preset tunes.
+type qualityPreset struct {
+	scaler    draw.Scaler
+	colors    int
+	dither    bool
+	quantizer draw.Quantizer
+}
+
+var qualityPresets = map[Quality]qualityPreset{
+	QualityFast: {
+		scaler: draw.ApproxBiLinear,
+		colors: 64,
+		dither: false,
+		quantizer: quantize.MedianCutQuantizer{
+			Aggregation: quantize.Mode,
+		},
+	},
+	QualityBalanced: {
+		scaler: draw.BiLinear,
+		colors: 128,
+		dither: true,
+		quantizer: quantize.MedianCutQuantizer{
+			Aggregation: quantize.Mean,
+		},
+	},
+	QualityBest: {
+		scaler: draw.CatmullRom,
+		colors: 255,
+		dither: true,
+		quantizer: quantize.MedianCutQuantizer{
+			Aggregation: quantize.Mean,
+		},
+	},
+}
+
+// String returns the name of the quality preset.
+func (q Quality) String() string {
+	switch q {
+	case QualityCustom:
+		return "custom"
+	case QualityFast:
+		return "fast"
+	case QualityBalanced:
+		return "balanced"
+	case QualityBest:
+		return "best"
+	default:
+		return "unknown"
+	}
+}
+
+// DefaultQuantizer is the quantizer used if ImageOpts' Quantizer is nil. It is
+// a median cut quantizer that averages the colors of each bucket, which gives
+// better color fidelity than picking the most common color.
+var DefaultQuantizer draw.Quantizer = quantize.MedianCutQuantizer{
+	Aggregation: quantize.Mean,
+}
+
+// withQuality returns a copy of the options with the Quality preset applied.
+// The quantizer is left nil if neither the options nor the preset choose one,
+// in which case DefaultQuantizer is used. Integer-scaled images always use the
+// nearest-neighbor scaler.
+func (opts ImageOpts) withQuality() ImageOpts {
+	if preset, ok := qualityPresets[opts.Quality]; ok {
+		opts.Scaler = preset.scaler
+		opts.Colors = preset.colors
+		opts.Dither = preset.dither
+		opts.Quantizer = preset.quantizer
+	}
+
+	// Pixel art must not be interpolated.
+	if opts.IntegerScale {
+		opts.Scaler = draw.NearestNeighbor
+	}
+
+	return opts
+}
+
+// palettedImage quantizes the given image into a paletted image using the
+// given quantizer. The palette leaves one color for the SIXEL encoder to
+// reserve for transparency.
+func palettedImage(src image.Image, colors int, dither bool, q draw.Quantizer) *image.Paletted {
+	if colors < 2 || colors > 255 {
+		colors = 255
+	}
+
+	palette := q.Quantize(make(color.Palette, 0, colors-1), src)
+	return drawPaletted(src, palette, dither)
+}
+
+// drawPaletted draws the given image onto a new paletted image with the given
+// palette.
+func drawPaletted(src image.Image, palette color.Palette, dither bool) *image.Paletted {
+	paletted := image.NewPaletted(src.Bounds(), palette)
+
+	if dither {
+		draw.FloydSteinberg.Draw(paletted, paletted.Bounds(), src, src.Bounds().Min)
+	} else {
+		draw.Draw(paletted, paletted.Bounds(), src, src.Bounds().Min, draw.Over)
+	}
+
+	return paletted
+}
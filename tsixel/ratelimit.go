@@ -0,0 +1,50 @@
+package tsixel
+
+import (
+	"image"
+	"time"
+)
+
+// rateLimiter holds back updates of an image to enforce ImageOpts' MaxFPS.
+// It must be guarded by the image's mutex.
+type rateLimiter struct {
+	last   time.Time       // time of the last update let through
+	bounds image.Rectangle // bounds of the last update let through
+	held   bool            // true if an update is being held back
+	waker  waker
+}
+
+// limitRate clears the frame's MustUpdate if the image was updated too
+// recently, and schedules a redraw for when the held back update is due.
+// Updates held back in the meantime are coalesced into the latest one.
+func (img *imageState) limitRate(frame *Frame, state DrawState) {
+	rate := &img.rate
+
+	// Never hold back synchronized draws or geometry changes, since the
+	// screen would be left with a stale image.
+	if img.opts.MaxFPS <= 0 || state.Sync || state.Offline || frame.Bounds != rate.bounds {
+		if frame.MustUpdate {
+			rate.last = state.Time
+			rate.bounds = frame.Bounds
+			rate.held = false
+		}
+		return
+	}
+
+	if !frame.MustUpdate && !rate.held {
+		return
+	}
+
+	interval := time.Duration(float64(time.Second) / img.opts.MaxFPS)
+
+	if due := rate.last.Add(interval); state.Time.Before(due) {
+		frame.MustUpdate = false
+		rate.held = true
+		rate.waker.wakeAfter(&img.l, due.Sub(state.Time), state.Delegate)
+		return
+	}
+
+	frame.MustUpdate = true
+	rate.last = state.Time
+	rate.held = false
+}
@@ -0,0 +1,20 @@
+package tsixel
+
+// RedrawPolicy determines how an image is redrawn when the cells under it
+// change.
+type RedrawPolicy uint8
+
+const (
+	// RedrawOnCellDamage redraws the image whenever the cells under it
+	// change, since the terminal draws the new cells over the image. It is the
+	// default policy.
+	RedrawOnCellDamage RedrawPolicy = iota
+	// IgnoreCellDamage keeps the image as-is when the cells under it change.
+	// The changed cells are not drawn over the image, so they only show once
+	// the image is gone or the screen is synchronized. This is useful for
+	// drawing alternative text under images.
+	IgnoreCellDamage
+	// ClearCellsFirst clears the cells under the image before every draw, so
+	// that anything drawn under the image is discarded.
+	ClearCellsFirst
+)
@@ -0,0 +1,174 @@
+package tsixel
+
+import (
+	"image"
+	"strings"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/mattn/go-runewidth"
+)
+
+// LineSpans calculates the free cells of each line within the given region
+// that are not occupied by any of the given rectangles. Each span is returned
+// as a rectangle of one line in height, ordered from top to bottom, then from
+// left to right. All units are in cells.
+func LineSpans(region image.Rectangle, occupied []image.Rectangle) []image.Rectangle {
+	var spans []image.Rectangle
+
+	for y := region.Min.Y; y < region.Max.Y; y++ {
+		x := region.Min.X
+
+		for x < region.Max.X {
+			// Skip the cells occupied by the rectangles.
+			if end := occupiedUntil(occupied, x, y); end > x {
+				x = end
+				continue
+			}
+
+			start := x
+			for x < region.Max.X && occupiedUntil(occupied, x, y) == x {
+				x++
+			}
+
+			spans = append(spans, image.Rect(start, y, x, y+1))
+		}
+	}
+
+	return spans
+}
+
+// occupiedUntil returns the end of the rectangle occupying the cell at (x, y),
+// or x if the cell is free.
+func occupiedUntil(occupied []image.Rectangle, x, y int) int {
+	pt := image.Pt(x, y)
+	end := x
+
+	for _, r := range occupied {
+		if pt.In(r) && r.Max.X > end {
+			end = r.Max.X
+		}
+	}
+
+	return end
+}
+
+// ReflowText draws the given text within the region on the screen, wrapping
+// words around the occupied rectangles like in a document. Newlines start a new
+// line, and words too long for a whole line are broken up. The text that did
+// not fit within the region is returned.
+func ReflowText(
+	screen tcell.Screen, region image.Rectangle, occupied []image.Rectangle,
+	text string, style tcell.Style) (rest string) {
+
+	spans := LineSpans(region, occupied)
+	words := reflowWords(text)
+
+	for len(spans) > 0 && len(words) > 0 {
+		span := spans[0]
+		x := span.Min.X
+
+		for len(words) > 0 {
+			word := words[0]
+
+			if word == "\n" {
+				words = words[1:]
+				// Skip the rest of the spans on this line.
+				for len(spans) > 0 && spans[0].Min.Y == span.Min.Y {
+					spans = spans[1:]
+				}
+				break
+			}
+
+			// Prepend a space if this isn't the first word in the span.
+			pad := 0
+			if x > span.Min.X {
+				pad = 1
+			}
+
+			width := runewidth.StringWidth(word)
+
+			if x+pad+width > span.Max.X {
+				// Break the word if it would never fit in a whole line.
+				if x == span.Min.X && width > region.Dx() {
+					head, tail := splitWidth(word, span.Dx())
+					x = drawString(screen, x, span.Min.Y, head, style)
+					words[0] = tail
+				}
+
+				spans = spans[1:]
+				break
+			}
+
+			x = drawString(screen, x+pad, span.Min.Y, word, style)
+			words = words[1:]
+
+			if len(words) == 0 {
+				return ""
+			}
+		}
+	}
+
+	return strings.Join(words, " ")
+}
+
+// ReflowText draws the given text within the region, wrapping words around the
+// images on the screen. It is a convenient wrapper around ReflowText. This
+// method will not redraw.
+func (s *Screen) ReflowText(region image.Rectangle, text string, style tcell.Style) (rest string) {
+	return ReflowText(s.s, region, s.ImageRegions(), text, style)
+}
+
+// ImageRegions returns the last drawn bounds of all images on the screen in
+// units of cells.
+func (s *Screen) ImageRegions() []image.Rectangle {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	regions := make([]image.Rectangle, 0, len(s.images))
+	for _, img := range s.images {
+		if !img.frame.Bounds.Empty() {
+			regions = append(regions, img.frame.Bounds)
+		}
+	}
+
+	return regions
+}
+
+// reflowWords splits the text into words, keeping newlines as their own words.
+func reflowWords(text string) []string {
+	var words []string
+
+	for i, line := range strings.Split(text, "\n") {
+		if i > 0 {
+			words = append(words, "\n")
+		}
+		words = append(words, strings.Fields(line)...)
+	}
+
+	return words
+}
+
+// splitWidth splits the string so that the head fits within the given width.
+// The head always has at least one rune.
+func splitWidth(s string, width int) (head, tail string) {
+	w := 0
+
+	for i, r := range s {
+		w += runewidth.RuneWidth(r)
+		if w > width && i > 0 {
+			return s[:i], s[i:]
+		}
+	}
+
+	return s, ""
+}
+
+// drawString draws the string starting at the given cell and returns the cell
+// after it.
+func drawString(screen tcell.Screen, x, y int, s string, style tcell.Style) int {
+	for _, r := range s {
+		screen.SetContent(x, y, r, nil, style)
+		x += runewidth.RuneWidth(r)
+	}
+	return x
+}
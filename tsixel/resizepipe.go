@@ -3,9 +3,12 @@ package tsixel
 import (
 	"bytes"
 	"context"
+	"errors"
+	"fmt"
 	"image"
 	"runtime"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/mattn/go-sixel"
@@ -17,6 +20,10 @@ var (
 	resizerMain ResizePipeline
 )
 
+// ErrJobTimeout is given to a job's callback if the job took longer than its
+// timeout.
+var ErrJobTimeout = errors.New("resizing job timed out")
+
 func init() {
 	resizerMain = *NewResizePipeline()
 	resizerMain.Start()
@@ -24,7 +31,7 @@ func init() {
 
 type ResizePipeline struct {
 	// state
-	queue   []*ResizerJob
+	queue   jobQueue
 	pool    *encoderPool
 	workers int
 
@@ -39,9 +46,15 @@ type ResizePipeline struct {
 	// The default is GOMAXPROCS.
 	maxWorkers int
 
+	// jobTimeout is the timeout of jobs that don't have their own.
+	//
+	// The default is no timeout.
+	jobTimeout time.Duration
+
 	// channels
 	dieCh     chan struct{} // worker death signals
 	msgCh     chan resizePipelineMessage
+	pauseCh   chan int         // pause hold deltas
 	jobCh     chan *ResizerJob // job queue
 	finishCh  chan *ResizerJob
 	distribCh chan *ResizerJob // job distribute
@@ -55,18 +68,102 @@ type ResizePipeline struct {
 // ResizerJob describes a resizing job. The resize pipeline will batch up jobs,
 // resize them asynchronously, and call the screen once it's done.
 type ResizerJob struct {
-	Done func(ResizerJob, []byte)
+	// Done is called with the encoded SIXEL once the job is done. If the
+	// encoding failed, then the error is non-nil and the bytes are nil.
+	Done func(ResizerJob, []byte, error)
+	// DoneBuffer, if not nil, is called instead of Done with the pooled
+	// output buffer, saving a copy. The callback owns the buffer and must
+	// release it once the bytes are no longer used. The buffer is nil if the
+	// error is non-nil.
+	DoneBuffer func(ResizerJob, *SIXELBuffer, error)
+
+	// Context, if not nil, is the job's context. Workers skip the job if the
+	// context is canceled before the job is run, and its callback is called
+	// with ErrJobDropped.
+	Context context.Context
 
 	SrcImg image.Image
 
 	Options ImageOpts
 	NewSize image.Point
+
+	// Priority is the priority class of the job. If a background job is
+	// dropped from a saturated queue, then its callback is called with
+	// ErrJobDropped.
+	Priority JobPriority
+	// Owner, if not nil, identifies the owner of the job. Queuing a job
+	// replaces any queued job with the same owner, so only the latest job of
+	// each owner is ever run. Owner must be comparable.
+	Owner interface{}
+
+	// Deadline, if not zero, is the time after which the job's result can no
+	// longer be used. Workers skip jobs past their deadline, and their
+	// callbacks are called with ErrJobDropped.
+	Deadline time.Time
+	// Timeout, if positive, is the duration that the job may take to resize
+	// and encode. If the job takes longer, then its callback is called with
+	// ErrJobTimeout right away, and its worker is replaced. The stalled
+	// encode keeps running in the background, and if it succeeds while the
+	// job isn't superseded, then the callback is called again with the late
+	// result. If Timeout is zero, then the pipeline's timeout is used; if it
+	// is negative, then the job never times out.
+	Timeout time.Duration
+	// Superseded, if not nil, is called by the worker right before the job is
+	// run. If it returns true, then the job is skipped, since its result would
+	// be discarded anyway. It is useful for skipping resizes to a size that
+	// has since changed again.
+	Superseded func(ResizerJob) bool
+
+	// Generation is the generation of the request that the job was made for.
+	// The pipeline doesn't use it; the callbacks compare it to tell whether
+	// the result is for the latest request, even if the size has changed and
+	// then changed back since. Images in this package stamp each job with a
+	// counter that increments for every job they create.
+	Generation uint64
+
+	// Elapsed is set by the worker to the time taken to resize and encode the
+	// image before the callback is called.
+	Elapsed time.Duration
+	// Degradation is set by the worker to how the image was degraded to fit
+	// the options' MaxBytes before the callback is called.
+	Degradation Degradation
+
+	// pixels is set by the worker to the scaled image if the options ask for
+	// it.
+	pixels *image.NRGBA
+}
+
+// expired returns true if the job's result can no longer be used because its
+// context is canceled or its deadline has passed.
+func (job *ResizerJob) expired(now time.Time) bool {
+	if job.Context != nil && job.Context.Err() != nil {
+		return true
+	}
+	return !job.Deadline.IsZero() && now.After(job.Deadline)
+}
+
+// superseded returns true if the job's result would be discarded by its owner.
+// Superseded jobs do not have their callbacks called, since their owners have
+// already moved on.
+func (job *ResizerJob) superseded() bool {
+	return job.Superseded != nil && job.Superseded(*job)
+}
+
+// fail calls the job's callback with the given error.
+func (job *ResizerJob) fail(err error) {
+	if job.DoneBuffer != nil {
+		job.DoneBuffer(*job, nil, err)
+	} else {
+		job.Done(*job, nil, err)
+	}
 }
 
 // resizePipelineMessage is an arbitrary message for the resize pipeline.
 type resizePipelineMessage struct {
 	BatchDuration time.Duration
 	MaxWorkers    int
+	MaxQueue      int
+	JobTimeout    time.Duration
 }
 
 func NewResizePipeline() *ResizePipeline {
@@ -84,12 +181,14 @@ func NewResizePipelineContext(ctx context.Context) *ResizePipeline {
 
 		dieCh:     make(chan struct{}),
 		msgCh:     make(chan resizePipelineMessage),
+		pauseCh:   make(chan int),
 		jobCh:     make(chan *ResizerJob),
 		distribCh: make(chan *ResizerJob),
 
-		pool: newEncoderPool(),
-		sctx: ctx,
-		stop: cancel,
+		queue: newJobQueue(DefaultMaxQueue),
+		pool:  newEncoderPool(),
+		sctx:  ctx,
+		stop:  cancel,
 	}
 }
 
@@ -115,13 +214,82 @@ func (pipeline *ResizePipeline) Stop() {
 	pipeline.done.Wait()
 }
 
+// SetMaxQueue sets the maximum number of queued jobs before background jobs
+// are dropped. Interactive jobs are always queued.
+func (pipeline *ResizePipeline) SetMaxQueue(max int) {
+	pipeline.sendMessage(resizePipelineMessage{MaxQueue: max})
+}
+
+// SetJobTimeout sets the timeout of queued jobs that don't have their own. A
+// zero or negative timeout disables it, which is the default. Since a stalled
+// encode can't be interrupted, a timed out job keeps running in the background,
+// but it no longer counts against the maximum number of workers.
+func (pipeline *ResizePipeline) SetJobTimeout(timeout time.Duration) {
+	if timeout <= 0 {
+		timeout = -1
+	}
+	pipeline.sendMessage(resizePipelineMessage{JobTimeout: timeout})
+}
+
+func (pipeline *ResizePipeline) sendMessage(msg resizePipelineMessage) {
+	select {
+	case <-pipeline.sctx.Done():
+	case pipeline.msgCh <- msg:
+	}
+}
+
+// Pause halts the distribution of jobs to workers. Jobs can still be queued,
+// and jobs already being run are not interrupted. Pauses are counted, so the
+// pipeline can be held by multiple callers: jobs are only distributed again
+// once every Pause is matched by a Resume.
+func (pipeline *ResizePipeline) Pause() {
+	pipeline.addPause(1)
+}
+
+// Resume releases a Pause. Calling Resume more times than Pause does nothing.
+func (pipeline *ResizePipeline) Resume() {
+	pipeline.addPause(-1)
+}
+
+func (pipeline *ResizePipeline) addPause(delta int) {
+	select {
+	case <-pipeline.sctx.Done():
+	case pipeline.pauseCh <- delta:
+	}
+}
+
 func (pipeline *ResizePipeline) start() {
+	defer pipeline.done.Done()
+
 	// TODO: batch and optimize
 
-	var distributeJob *ResizerJob
-	var distributeCh chan *ResizerJob
+	var paused int // number of pause holders
 
 	for {
+		// Only distribute if we have a job to distribute.
+		var distributeCh chan *ResizerJob
+		var distributeJob *ResizerJob
+
+		if paused == 0 {
+			distributeJob = pipeline.queue.peek()
+		}
+
+		if distributeJob != nil {
+			distributeCh = pipeline.distribCh
+
+			// Workers die when they're idle, so ensure that there's enough
+			// of them for the queued jobs.
+			if pipeline.workers < pipeline.maxWorkers {
+				pipeline.workers++
+
+				go resizeWorker(pipeline.sctx, worker{
+					pool:    pipeline.pool,
+					distrib: pipeline.distribCh,
+					die:     pipeline.dieCh,
+				})
+			}
+		}
+
 		select {
 		case <-pipeline.sctx.Done():
 			return
@@ -132,6 +300,11 @@ func (pipeline *ResizePipeline) start() {
 				panic("negative pipeline.workers")
 			}
 
+		case delta := <-pipeline.pauseCh:
+			if paused += delta; paused < 0 {
+				paused = 0
+			}
+
 		case msg := <-pipeline.msgCh:
 			if msg.MaxWorkers > 0 {
 				pipeline.maxWorkers = msg.MaxWorkers
@@ -139,51 +312,39 @@ func (pipeline *ResizePipeline) start() {
 			if msg.BatchDuration > 0 {
 				pipeline.batchDuration = msg.BatchDuration
 			}
+			if msg.MaxQueue > 0 {
+				pipeline.queue.max = msg.MaxQueue
+			}
+			if msg.JobTimeout != 0 {
+				pipeline.jobTimeout = msg.JobTimeout
+			}
 
 		case job := <-pipeline.jobCh:
-			distributeCh = pipeline.distribCh
-
-			// Append into an unbounded queue if we already have a job.
-			// Otherwise, use it immediately.
-			if distributeJob != nil {
-				pipeline.queue = append(pipeline.queue, job)
-			} else {
-				distributeJob = job
+			if job.Timeout == 0 {
+				job.Timeout = pipeline.jobTimeout
 			}
 
-			if pipeline.workers < pipeline.maxWorkers {
-				pipeline.workers++
-
-				go resizeWorker(pipeline.sctx, worker{
-					pool:    pipeline.pool,
-					distrib: pipeline.distribCh,
-					die:     pipeline.dieCh,
-				})
+			// Dropped jobs are never run, so let their owners know. The
+			// callback may lock its owner, which may be queuing another job,
+			// so it must not block the pipeline.
+			if dropped := pipeline.queue.push(job); dropped != nil {
+				debugf("dropped job resizing to %v", dropped.NewSize)
+				go dropped.fail(ErrJobDropped)
 			}
+			debugf("queued job resizing to %v (%d queued)", job.NewSize, pipeline.queue.len())
 
 		case distributeCh <- distributeJob:
-			// Mark job as empty.
-			distributeJob = nil
-
-			// Stop sending jobs if we're out of them.
-			if len(pipeline.queue) == 0 {
-				distributeCh = nil
-				continue
-			}
-
-			// Rotate to the next job in FIFO order.
-			distributeJob = pipeline.queue[0]
-
-			// Pop the rotated job off.
-			copy(pipeline.queue, pipeline.queue[1:])                // shift leftwards
-			pipeline.queue[len(pipeline.queue)-1] = nil             // invalidate last
-			pipeline.queue = pipeline.queue[:len(pipeline.queue)-1] // pop last
+			pipeline.queue.pop()
+			debugf("dispatched job resizing to %v", distributeJob.NewSize)
 		}
 	}
 }
 
-// QueueJob queues a resizing job. If a job with the same Imager is already
-// queued, then its size is updated and the callback is preserved.
+// QueueJob queues a resizing job. If a job with the same Owner is already
+// queued, then it is replaced with the given job. Interactive jobs are
+// dispatched before background jobs, and background jobs may be dropped if the
+// queue is saturated, in which case their callbacks are called with
+// ErrJobDropped.
 func (pipeline *ResizePipeline) QueueJob(job ResizerJob) {
 	select {
 	case <-pipeline.sctx.Done():
@@ -208,26 +369,128 @@ EventLoop:
 			return
 
 		case job := <-w.distrib:
-			bytes := w.pool.do(job.SrcImg, job.NewSize, job.Options)
-			job.Done(*job, bytes)
+			if job.expired(time.Now()) {
+				debugf("skipped expired job resizing to %v", job.NewSize)
+				job.fail(ErrJobDropped)
+				continue
+			}
+
+			if job.superseded() {
+				debugf("skipped stale job resizing to %v", job.NewSize)
+				continue
+			}
+
+			// A timed out worker was already replaced, so it must not
+			// take any more jobs.
+			if !w.run(ctx, job) {
+				return
+			}
 
 		default:
 			break EventLoop
 		}
 	}
 
-	// signal the worker's death and bail
+	w.signalDeath(ctx)
+}
+
+// signalDeath signals the pipeline that the worker no longer takes jobs.
+func (w worker) signalDeath(ctx context.Context) {
 	select {
 	case <-ctx.Done(): // beware of expiry
 	case w.die <- struct{}{}:
 	}
+}
+
+// run runs the job and calls its callback. If the job times out, then the
+// callback is called with ErrJobTimeout by the job's timer, which also signals
+// the worker's death so that the pipeline replaces it. In that case, false is
+// returned once the encode is done, and the result is only delivered if the
+// job isn't superseded.
+func (w worker) run(ctx context.Context, job *ResizerJob) bool {
+	start := time.Now()
+
+	// The state is swapped from jobRunning by whichever of the worker and the
+	// timer is done first.
+	state := int32(jobRunning)
+
+	if job.Timeout > 0 {
+		timeout := job.Timeout
+		timer := time.AfterFunc(timeout, func() {
+			if !atomic.CompareAndSwapInt32(&state, jobRunning, jobTimedOut) {
+				return
+			}
+
+			debugf("job resizing to %v timed out after %v", job.NewSize, timeout)
+			job.fail(fmt.Errorf("%w after %v", ErrJobTimeout, timeout))
+
+			// The encode can't be interrupted, so free up its slot for
+			// another worker while it stalls.
+			w.signalDeath(ctx)
+		})
+		defer timer.Stop()
+	}
+
+	enc, degraded, err := w.pool.encode(job.SrcImg, job.NewSize, job.Options)
+
+	if !atomic.CompareAndSwapInt32(&state, jobRunning, jobDone) {
+		// The timer may still be reading the job, so work on a copy.
+		late := *job
+
+		if err != nil || late.superseded() {
+			debugf("discarded late job resizing to %v", late.NewSize)
+			if err == nil {
+				w.pool.put(enc)
+			}
+			return false
+		}
+
+		w.deliver(&late, enc, degraded, time.Since(start))
+		return false
+	}
+
+	if err != nil {
+		job.Elapsed = time.Since(start)
+		job.Degradation = degraded
+		debugf("job resizing to %v failed after %v: %v", job.NewSize, job.Elapsed, err)
+		job.fail(err)
+		return true
+	}
+
+	w.deliver(job, enc, degraded, time.Since(start))
+	return true
+}
+
+// deliver calls the job's callback with the encoded SIXEL.
+func (w worker) deliver(job *ResizerJob, enc pooledEncoder, degraded Degradation, elapsed time.Duration) {
+	job.Elapsed = elapsed
+	job.Degradation = degraded
+	job.pixels = enc.pixels
+
+	debugf("job resizing to %v done in %v (%d bytes)", job.NewSize, job.Elapsed, enc.buf.Len())
+
+	if job.DoneBuffer != nil {
+		job.DoneBuffer(*job, &SIXELBuffer{enc: enc, pool: w.pool}, nil)
+		return
+	}
 
-	return
+	b := enc.Bytes()
+	w.pool.put(enc)
+	job.Done(*job, b, nil)
 }
 
+// States of a running job.
+const (
+	jobRunning = iota
+	jobDone
+	jobTimedOut
+)
+
 type pooledEncoder struct {
 	*sixel.Encoder
 	buf *bytes.Buffer
+
+	pixels *image.NRGBA // scaled image if asked for by the options
 }
 
 func newPooledEncoder(cap int) pooledEncoder {
@@ -260,20 +523,47 @@ func (encp *encoderPool) take() pooledEncoder {
 
 func (encp *encoderPool) put(enc pooledEncoder) {
 	enc.buf.Reset()
+	enc.pixels = nil
 	(*sync.Pool)(encp).Put(enc)
 }
 
-func (encp *encoderPool) do(src image.Image, sz image.Point, opts ImageOpts) []byte {
-	// TODO: pool the image's backing array
+func (encp *encoderPool) do(src image.Image, sz image.Point, opts ImageOpts) ([]byte, Degradation, error) {
+	enc, degraded, err := encp.encode(src, sz, opts)
+	if err != nil {
+		return nil, Degradation{}, err
+	}
+	defer encp.put(enc)
+
+	return enc.Bytes(), degraded, nil
+}
+
+// lazyImage is implemented by source images that are only rendered once
+// they're needed, so that the rendering happens in the resize pipeline.
+type lazyImage interface {
+	image.Image
+	render() image.Image
+}
+
+// encodeOnce resizes and encodes the given image into a pooled encoder. The
+// caller must put the encoder back once it's done with the encoder's buffer. If
+// an error is returned, then the encoder is already put back.
+func (encp *encoderPool) encodeOnce(src image.Image, sz image.Point, opts ImageOpts) (pooledEncoder, error) {
+	opts = opts.withQuality()
+
+	if lazy, ok := src.(lazyImage); ok {
+		src = lazy.render()
+	}
+
 	// TODO: use something better than sync.Pool
-	dst := image.NewRGBA(image.Rectangle{Max: sz})
+	dst := rgbaPool.take(sz)
+	defer rgbaPool.put(dst)
 
-	// Clip the new image if we don't scale. Otherwise, scale the image
-	// onto the new one as usual.
+	// Clip the new image from the scroll point if we don't scale. Otherwise,
+	// scale the image onto the new one as usual.
 	if opts.Scaler == nil {
 		draw.Draw(
 			dst, dst.Bounds(),
-			src, image.Pt(0, 0), draw.Over,
+			src, opts.scroll, draw.Over,
 		)
 	} else {
 		opts.Scaler.Scale(
@@ -282,11 +572,164 @@ func (encp *encoderPool) do(src image.Image, sz image.Point, opts ImageOpts) []b
 		)
 	}
 
+	// Crop off the part of the image that is outside the screen.
+	img := dst
+	if opts.Overflow == OverflowClip {
+		img = dst.SubImage(image.Rectangle{Max: opts.clip}).(*image.RGBA)
+	}
+
+	opts.adjustColors(img)
+	obscureImage(img, opts.obscure)
+
 	enc := encp.take()
-	defer encp.put(enc)
 
 	enc.Encoder.Dither = opts.Dither
-	enc.Encoder.Encode(dst)
+	enc.Encoder.Colors = opts.Colors
+
+	var paletted *image.Paletted
+
+	// Map the image onto a fixed palette if asked to, which skips the costly
+	// quantization. Otherwise, quantize the image ourselves using the chosen
+	// quantizer, or DefaultQuantizer if none. The encoder will use the paletted
+	// image as-is.
+	if palette := opts.fixedPalette(); palette != nil {
+		paletted = drawPaletted(img, palette, opts.Dither)
+		enc.Encoder.Colors = len(palette) + 1
+	} else {
+		quantizer := opts.Quantizer
+		if quantizer == nil {
+			quantizer = DefaultQuantizer
+		}
+
+		paletted = palettedImage(img, opts.Colors, opts.Dither, quantizer)
+	}
+
+	if opts.transparent {
+		paletted = maskTransparent(paletted, img)
+		enc.Encoder.Colors = len(paletted.Palette) + 1
+	}
+
+	if opts.offset != (image.Point{}) {
+		paletted = padPaletted(paletted, opts.offset)
+		enc.Encoder.Colors = len(paletted.Palette) + 1
+	}
+
+	err := enc.Encoder.Encode(paletted)
+
+	if err != nil {
+		encp.put(enc)
+		return pooledEncoder{}, fmt.Errorf("failed to encode SIXEL: %w", err)
+	}
+
+	// Keep the padding and the transparent pixels transparent.
+	if opts.offset != (image.Point{}) || opts.transparent {
+		keepBackground(enc.buf.Bytes())
+	}
+
+	if opts.pixels {
+		enc.pixels = fallbackPixels(img, opts.offset)
+	}
+
+	return enc, nil
+}
+
+// SIXELBuffer is a pooled buffer containing the encoded SIXEL output of a
+// resizing job. It is given to ResizerJob's DoneBuffer callback, which owns it
+// until Release is called.
+type SIXELBuffer struct {
+	enc  pooledEncoder
+	pool *encoderPool
+}
+
+// Bytes returns the SIXEL bytes. The returned slice is only valid until the
+// buffer is released.
+func (buf *SIXELBuffer) Bytes() []byte {
+	return buf.enc.buf.Bytes()
+}
+
+// Release puts the buffer back into the pool. The buffer and its bytes must not
+// be used afterwards. Calling Release on a nil buffer does nothing.
+func (buf *SIXELBuffer) Release() {
+	if buf == nil || buf.pool == nil {
+		return
+	}
+
+	buf.pool.put(buf.enc)
+	buf.pool = nil
+}
+
+// rgbaPool is the global pool of destination images.
+var rgbaPool = newRGBAPool()
+
+// rgbaImagePool pools the backing arrays of RGBA images. Arrays are pooled in
+// size classes of powers of 2, so images of similar sizes can share arrays.
+type rgbaImagePool struct {
+	mu      sync.Mutex
+	classes map[int]*sync.Pool
+}
+
+func newRGBAPool() *rgbaImagePool {
+	return &rgbaImagePool{
+		classes: map[int]*sync.Pool{},
+	}
+}
+
+// rgbaSizeClass returns the smallest power of 2 that can hold n bytes.
+func rgbaSizeClass(n int) int {
+	class := 1
+	for class < n {
+		class <<= 1
+	}
+	return class
+}
+
+func (pool *rgbaImagePool) class(class int) *sync.Pool {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	p, ok := pool.classes[class]
+	if !ok {
+		p = &sync.Pool{
+			New: func() interface{} {
+				b := make([]uint8, class)
+				return &b
+			},
+		}
+		pool.classes[class] = p
+	}
+
+	return p
+}
+
+// take returns a cleared RGBA image of the given size.
+func (pool *rgbaImagePool) take(sz image.Point) *image.RGBA {
+	n := 4 * sz.X * sz.Y
+	if n <= 0 {
+		return image.NewRGBA(image.Rectangle{})
+	}
+
+	pix := *(pool.class(rgbaSizeClass(n)).Get().(*[]uint8))
+	pix = pix[:n]
+
+	// Clear the array, since images are drawn over.
+	for i := range pix {
+		pix[i] = 0
+	}
+
+	return &image.RGBA{
+		Pix:    pix,
+		Stride: 4 * sz.X,
+		Rect:   image.Rectangle{Max: sz},
+	}
+}
+
+// put puts the image's backing array back into the pool. The image must not be
+// used afterwards.
+func (pool *rgbaImagePool) put(img *image.RGBA) {
+	if cap(img.Pix) == 0 {
+		return
+	}
 
-	return enc.Bytes()
+	pix := img.Pix[:cap(img.Pix)]
+	pool.class(cap(pix)).Put(&pix)
 }
@@ -0,0 +1,134 @@
+package tsixel
+
+import "errors"
+
+// ErrJobDropped is given to a job's callback if the job is dropped from a
+// saturated queue, or if it's skipped because its context is canceled or its
+// deadline has passed. The job's owner may queue it again later.
+var ErrJobDropped = errors.New("resizing job dropped")
+
+// JobPriority is the priority class of a resizing job.
+type JobPriority uint8
+
+const (
+	// PriorityInteractive is the priority for jobs whose results are needed on
+	// the screen as soon as possible, such as animation frames. It is the
+	// zero-value. Interactive jobs are never dropped.
+	PriorityInteractive JobPriority = iota
+	// PriorityBackground is the priority for jobs that can wait, such as
+	// thumbnails. Background jobs are only dispatched when there are no
+	// interactive jobs queued, and the oldest ones are dropped when the queue
+	// is saturated.
+	PriorityBackground
+)
+
+// DefaultMaxQueue is the default number of jobs that can be queued in a
+// ResizePipeline before background jobs are dropped.
+const DefaultMaxQueue = 64
+
+// jobQueue is a bounded queue of resizing jobs with priority classes. Jobs
+// with the same owner are coalesced.
+type jobQueue struct {
+	interactive []*ResizerJob
+	background  []*ResizerJob
+	max         int
+}
+
+func newJobQueue(max int) jobQueue {
+	return jobQueue{max: max}
+}
+
+// len returns the total number of queued jobs.
+func (q *jobQueue) len() int {
+	return len(q.interactive) + len(q.background)
+}
+
+// push pushes the given job into the queue. If a job with the same owner is
+// already queued, then it is replaced with the new job; the replaced job is
+// superseded, so it is not considered dropped. The job dropped to make room is
+// returned, or nil if none.
+func (q *jobQueue) push(job *ResizerJob) (dropped *ResizerJob) {
+	if job.Owner != nil {
+		if old := q.removeOwner(job.Owner); old != nil {
+			// The old job is replaced, so the queue has room.
+			q.append(job)
+			return nil
+		}
+	}
+
+	if q.max > 0 && q.len() >= q.max {
+		switch {
+		case len(q.background) > 0:
+			dropped = popJob(&q.background)
+		case job.Priority == PriorityBackground:
+			return job
+		}
+	}
+
+	q.append(job)
+	return dropped
+}
+
+func (q *jobQueue) append(job *ResizerJob) {
+	if job.Priority == PriorityBackground {
+		q.background = append(q.background, job)
+	} else {
+		q.interactive = append(q.interactive, job)
+	}
+}
+
+// removeOwner removes the job with the given owner and returns it.
+func (q *jobQueue) removeOwner(owner interface{}) *ResizerJob {
+	if job := removeJobOwner(&q.interactive, owner); job != nil {
+		return job
+	}
+	return removeJobOwner(&q.background, owner)
+}
+
+// peek returns the next job to be dispatched without removing it, or nil if
+// the queue is empty.
+func (q *jobQueue) peek() *ResizerJob {
+	if len(q.interactive) > 0 {
+		return q.interactive[0]
+	}
+	if len(q.background) > 0 {
+		return q.background[0]
+	}
+	return nil
+}
+
+// pop removes the next job to be dispatched.
+func (q *jobQueue) pop() *ResizerJob {
+	if len(q.interactive) > 0 {
+		return popJob(&q.interactive)
+	}
+	if len(q.background) > 0 {
+		return popJob(&q.background)
+	}
+	return nil
+}
+
+// popJob pops the first job off the given queue in FIFO order.
+func popJob(queue *[]*ResizerJob) *ResizerJob {
+	return removeJobAt(queue, 0)
+}
+
+func removeJobOwner(queue *[]*ResizerJob, owner interface{}) *ResizerJob {
+	for i, job := range *queue {
+		if job.Owner == owner {
+			return removeJobAt(queue, i)
+		}
+	}
+	return nil
+}
+
+func removeJobAt(queue *[]*ResizerJob, i int) *ResizerJob {
+	q := *queue
+	job := q[i]
+
+	copy(q[i:], q[i+1:]) // shift leftwards
+	q[len(q)-1] = nil    // invalidate last
+	*queue = q[:len(q)-1]
+
+	return job
+}
@@ -0,0 +1,170 @@
+package tsixel
+
+import (
+	"image"
+	"sync"
+	"sync/atomic"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+// SimulationScreen wraps around a tcell simulation screen to implement the
+// interfaces that a Screen requires, so that code using tsixel can be tested
+// without a terminal. SIXELs and other bytes drawn directly are captured
+// instead of being written anywhere.
+type SimulationScreen struct {
+	tcell.SimulationScreen
+	mu sync.Mutex
+
+	cellSize atomic.Value // image.Point
+	output   []byte
+
+	// cells mirrors the simulation screen's cells for damage tracking
+	cells tcell.CellBuffer
+
+	before []tcell.DrawInterceptFunc // newest first
+	after  []tcell.DrawInterceptFunc // newest first
+}
+
+var (
+	_ tcell.DirectDrawer       = (*SimulationScreen)(nil)
+	_ tcell.CellBufferViewer   = (*SimulationScreen)(nil)
+	_ tcell.PixelSizer         = (*SimulationScreen)(nil)
+	_ tcell.DrawInterceptAdder = (*SimulationScreen)(nil)
+	_ sync.Locker              = (*SimulationScreen)(nil)
+)
+
+// WrapSimulationScreen wraps around the given simulation screen. Each cell is
+// pretended to be of the given size in pixels. The simulation screen should be
+// initialized before it is given to WrapInitScreen.
+func WrapSimulationScreen(sim tcell.SimulationScreen, cellSize image.Point) *SimulationScreen {
+	screen := &SimulationScreen{SimulationScreen: sim}
+	screen.cellSize.Store(cellSize)
+
+	return screen
+}
+
+// Lock locks the screen. It implements sync.Locker.
+func (sim *SimulationScreen) Lock() { sim.mu.Lock() }
+
+// Unlock unlocks the screen. It implements sync.Locker.
+func (sim *SimulationScreen) Unlock() { sim.mu.Unlock() }
+
+// SetCellSize sets the size of each cell in pixels.
+func (sim *SimulationScreen) SetCellSize(cellSize image.Point) {
+	sim.cellSize.Store(cellSize)
+}
+
+// PixelSize returns the size of the screen in pixels. It implements
+// tcell.PixelSizer.
+func (sim *SimulationScreen) PixelSize() (int, int) {
+	w, h := sim.Size()
+	cellSize := sim.cellSize.Load().(image.Point)
+	return w * cellSize.X, h * cellSize.Y
+}
+
+// ViewCellBuffer exposes a cell buffer that mirrors the simulation screen's
+// cells, so that damage tracking works like it does on a terminal. The screen
+// must be locked, such as within draw intercepts. It implements
+// tcell.CellBufferViewer.
+func (sim *SimulationScreen) ViewCellBuffer(f func(*tcell.CellBuffer)) {
+	f(&sim.cells)
+}
+
+// DrawDirectly captures the given bytes. It implements tcell.DirectDrawer.
+func (sim *SimulationScreen) DrawDirectly(b []byte) {
+	sim.output = append(sim.output, b...)
+}
+
+// Output returns a copy of all bytes drawn directly so far.
+func (sim *SimulationScreen) Output() []byte {
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+
+	return append([]byte(nil), sim.output...)
+}
+
+// ResetOutput discards all bytes drawn directly so far.
+func (sim *SimulationScreen) ResetOutput() {
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+
+	sim.output = sim.output[:0]
+}
+
+// AddDrawIntercept adds a function to be called before the screen is drawn.
+// Similarly to tcell, newer intercepts are called first. It implements
+// tcell.DrawInterceptAdder.
+func (sim *SimulationScreen) AddDrawIntercept(fn tcell.DrawInterceptFunc) {
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+
+	sim.before = append([]tcell.DrawInterceptFunc{fn}, sim.before...)
+}
+
+// AddDrawInterceptAfter adds a function to be called after the screen is
+// drawn. It implements tcell.DrawInterceptAdder.
+func (sim *SimulationScreen) AddDrawInterceptAfter(fn tcell.DrawInterceptFunc) {
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+
+	sim.after = append([]tcell.DrawInterceptFunc{fn}, sim.after...)
+}
+
+// Show draws the screen and calls the draw intercepts.
+func (sim *SimulationScreen) Show() {
+	sim.draw(false)
+}
+
+// Sync synchronizes the screen and calls the draw intercepts.
+func (sim *SimulationScreen) Sync() {
+	sim.draw(true)
+}
+
+func (sim *SimulationScreen) draw(sync bool) {
+	// Intercepts are called with the screen locked, like in tcell.
+	sim.mu.Lock()
+	sim.mirrorCells()
+	if runIntercepts(sim.before, sim, sync) {
+		sync = true
+	}
+	sim.mu.Unlock()
+
+	if sync {
+		sim.SimulationScreen.Sync()
+	} else {
+		sim.SimulationScreen.Show()
+	}
+
+	sim.mu.Lock()
+	sim.drawCells()
+	runIntercepts(sim.after, sim, sync)
+	sim.mu.Unlock()
+}
+
+// mirrorCells copies the simulation screen's cells into the mirrored cell
+// buffer. Cells that changed since they were last drawn are marked dirty.
+func (sim *SimulationScreen) mirrorCells() {
+	w, h := sim.Size()
+	sim.cells.Resize(w, h)
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			mainc, combc, style, _ := sim.GetContent(x, y)
+			sim.cells.SetContent(x, y, mainc, combc, style)
+		}
+	}
+}
+
+// drawCells marks all mirrored cells as drawn, since the simulation screen
+// draws all of them.
+func (sim *SimulationScreen) drawCells() {
+	sim.mirrorCells()
+
+	w, h := sim.cells.Size()
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			sim.cells.SetDirty(x, y, false)
+		}
+	}
+}
@@ -0,0 +1,422 @@
+package tsixel
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/gif"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+// SlideSource is a source of a slide in a Slideshow.
+type SlideSource interface {
+	// LoadSlide loads the slide into an Imager using the given options. It is
+	// called in a background goroutine, and it may be called again if the
+	// slide was evicted.
+	LoadSlide(opts ImageOpts) (Imager, error)
+}
+
+// SlidePath is a slide source that loads the image at the given path. The
+// image's format must be registered. GIFs with multiple frames are loaded as
+// animations.
+type SlidePath string
+
+// LoadSlide implements SlideSource.
+func (path SlidePath) LoadSlide(opts ImageOpts) (Imager, error) {
+	f, err := os.Open(string(path))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return decodeSlide(f, opts)
+}
+
+// SlideReader creates a slide source that decodes the image from the given
+// reader. The reader is only read once; the decoded image is kept for when the
+// slide is loaded again.
+func SlideReader(r io.Reader) SlideSource {
+	return &readerSlide{r: r}
+}
+
+type readerSlide struct {
+	mu  sync.Mutex
+	r   io.Reader
+	img image.Image
+	gif *gif.GIF
+	err error
+}
+
+func (slide *readerSlide) LoadSlide(opts ImageOpts) (Imager, error) {
+	slide.mu.Lock()
+	defer slide.mu.Unlock()
+
+	if slide.r != nil {
+		slide.img, slide.gif, slide.err = decodeSlideSource(slide.r)
+		slide.r = nil
+	}
+
+	return newSlideImager(slide.img, slide.gif, opts), slide.err
+}
+
+// SlideImager creates a slide source from an existing Imager. The Imager is
+// used as-is, so the Slideshow does not position it.
+func SlideImager(img Imager) SlideSource {
+	return imagerSlide{img}
+}
+
+type imagerSlide struct{ img Imager }
+
+func (slide imagerSlide) LoadSlide(ImageOpts) (Imager, error) { return slide.img, nil }
+
+func decodeSlide(r io.Reader, opts ImageOpts) (Imager, error) {
+	img, g, err := decodeSlideSource(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return newSlideImager(img, g, opts), nil
+}
+
+// decodeSlideSource decodes either an image or an animated GIF from the given
+// reader.
+func decodeSlideSource(r io.Reader) (image.Image, *gif.GIF, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read slide: %w", err)
+	}
+
+	_, format, err := image.DecodeConfig(bytes.NewReader(b))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to decode slide: %w", err)
+	}
+
+	if format == "gif" {
+		g, err := gif.DecodeAll(bytes.NewReader(b))
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to decode GIF slide: %w", err)
+		}
+
+		if len(g.Image) > 1 {
+			return nil, g, nil
+		}
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(b))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to decode slide: %w", err)
+	}
+
+	return img, nil, nil
+}
+
+func newSlideImager(img image.Image, g *gif.GIF, opts ImageOpts) Imager {
+	switch {
+	case g != nil:
+		return NewAnimation(g, opts)
+	case img != nil:
+		return NewImage(img, opts)
+	default:
+		return nil
+	}
+}
+
+// slidePlacer is implemented by images that the Slideshow can position.
+type slidePlacer interface {
+	SetSize(image.Point)
+	SetPosition(image.Point)
+}
+
+// Slideshow is an Imager that shows a list of slides one at a time in a single
+// screen slot. The next slide is loaded and encoded in the background, and
+// only the current slide and its neighbors are kept in memory.
+type Slideshow struct {
+	l sync.Mutex
+
+	sources  []SlideSource
+	opts     ImageOpts
+	bounds   image.Rectangle
+	interval time.Duration
+
+	slides   map[int]*loadedSlide
+	index    int
+	shownAt  time.Time
+	changed  bool
+	state    DrawState // last state, used to prepare the next slide
+	delegate func()
+	waker    waker
+}
+
+var _ GeometryImager = (*Slideshow)(nil)
+
+// loadedSlide is a slide that is either loading or loaded.
+type loadedSlide struct {
+	img      Imager
+	err      error
+	loaded   bool
+	fixed    bool // true if the slide must not be positioned
+	prepared bool // true if the slide's SIXEL was queued ahead of time
+}
+
+// NewSlideshow creates a new slideshow with the given slide sources. The
+// options are used for slides loaded from images.
+func NewSlideshow(sources []SlideSource, opts ImageOpts) *Slideshow {
+	return &Slideshow{
+		sources:  sources,
+		opts:     opts,
+		slides:   map[int]*loadedSlide{},
+		delegate: func() {},
+	}
+}
+
+// SetPosition sets the top-left corner of the slideshow in units of cells.
+func (show *Slideshow) SetPosition(pos image.Point) {
+	show.l.Lock()
+	defer show.l.Unlock()
+
+	size := show.bounds.Size()
+	show.bounds.Min = pos
+	show.bounds.Max = pos.Add(size)
+	show.placeAll()
+}
+
+// SetSize sets the size of the slideshow in units of cells.
+func (show *Slideshow) SetSize(size image.Point) {
+	show.l.Lock()
+	defer show.l.Unlock()
+
+	show.bounds.Max = show.bounds.Min.Add(size)
+	show.placeAll()
+}
+
+// SetInterval sets the interval to automatically advance the slides. A zero
+// interval disables advancing automatically.
+func (show *Slideshow) SetInterval(interval time.Duration) {
+	show.l.Lock()
+	defer show.l.Unlock()
+
+	show.interval = interval
+}
+
+// Index returns the index of the current slide.
+func (show *Slideshow) Index() int {
+	show.l.Lock()
+	defer show.l.Unlock()
+
+	return show.index
+}
+
+// Next advances to the next slide, wrapping around. This method will not
+// redraw.
+func (show *Slideshow) Next() {
+	show.l.Lock()
+	defer show.l.Unlock()
+
+	show.seek(show.index + 1)
+}
+
+// Prev goes back to the previous slide, wrapping around. This method will not
+// redraw.
+func (show *Slideshow) Prev() {
+	show.l.Lock()
+	defer show.l.Unlock()
+
+	show.seek(show.index - 1)
+}
+
+// Seek jumps to the slide at the given index, wrapping around. This method
+// will not redraw.
+func (show *Slideshow) Seek(index int) {
+	show.l.Lock()
+	defer show.l.Unlock()
+
+	show.seek(index)
+}
+
+func (show *Slideshow) seek(index int) {
+	if len(show.sources) == 0 {
+		return
+	}
+
+	index %= len(show.sources)
+	if index < 0 {
+		index += len(show.sources)
+	}
+
+	show.index = index
+	show.shownAt = time.Time{}
+	show.changed = true
+}
+
+// Geometry returns the geometry of the current slide. If the slide can't report
+// its geometry, such as when it's still loading, then only the requested bounds
+// are returned. It implements GeometryImager.
+func (show *Slideshow) Geometry() Geometry {
+	show.l.Lock()
+	defer show.l.Unlock()
+
+	if slide, ok := show.slides[show.index]; ok && slide.loaded && slide.err == nil {
+		if geom, ok := slide.img.(GeometryImager); ok {
+			return geom.Geometry()
+		}
+	}
+
+	return Geometry{Requested: show.bounds}
+}
+
+// wrap wraps the given index around the number of slides.
+func (show *Slideshow) wrap(index int) int {
+	n := len(show.sources)
+	return ((index % n) + n) % n
+}
+
+// Update updates the current slide. It implements Imager.
+func (show *Slideshow) Update(state DrawState) Frame {
+	show.l.Lock()
+	defer show.l.Unlock()
+
+	if len(show.sources) == 0 {
+		return Frame{}
+	}
+
+	show.state = state
+	show.delegate = state.Delegate
+
+	// Advance the slide if the interval has elapsed.
+	if show.interval > 0 && !state.Offline {
+		if show.shownAt.IsZero() {
+			show.shownAt = state.Time
+		}
+
+		if next := show.shownAt.Add(show.interval); !state.Time.Before(next) {
+			show.seek(show.index + 1)
+			show.shownAt = state.Time
+		}
+
+		show.waker.wakeAfter(&show.l, show.shownAt.Add(show.interval).Sub(state.Time), state.Delegate)
+	}
+
+	// Load the current slide and preload the next one, then evict the rest.
+	show.load(show.index, state.Offline)
+	show.load(show.wrap(show.index+1), state.Offline)
+	show.evict()
+	show.prepare(show.slides[show.wrap(show.index+1)])
+
+	changed := show.changed
+	show.changed = false
+
+	slide := show.slides[show.index]
+
+	var frame Frame
+
+	switch {
+	case !slide.loaded:
+		// Draw nothing until the slide is loaded.
+	case slide.err != nil:
+		frame = Frame{Bounds: show.bounds, Err: slide.err}
+	default:
+		frame = slide.img.Update(state)
+	}
+
+	frame.MustUpdate = frame.MustUpdate || changed
+	return frame
+}
+
+// load starts loading the slide at the given index if it's not loaded yet. The
+// slide is loaded synchronously if sync is true.
+func (show *Slideshow) load(index int, sync bool) {
+	if _, ok := show.slides[index]; ok {
+		return
+	}
+
+	source := show.sources[index]
+	opts := show.opts
+
+	_, fixed := source.(imagerSlide)
+
+	slide := &loadedSlide{fixed: fixed}
+	show.slides[index] = slide
+
+	if sync {
+		slide.img, slide.err = source.LoadSlide(opts)
+		slide.loaded = true
+		show.place(slide)
+		return
+	}
+
+	go func() {
+		img, err := source.LoadSlide(opts)
+
+		show.l.Lock()
+		slide.img, slide.err = img, err
+		slide.loaded = true
+		show.place(slide)
+		isCurrent := show.slides[show.index] == slide
+		if !isCurrent && show.slides[show.wrap(show.index+1)] == slide {
+			show.prepare(slide)
+		}
+		delegate := show.delegate
+		show.l.Unlock()
+
+		// Redraw if we're the slide being waited on.
+		if isCurrent {
+			delegate()
+		}
+	}()
+}
+
+// prepare updates the given slide once it's loaded without drawing it, so its
+// SIXEL is encoded in the background before the slide is shown.
+func (show *Slideshow) prepare(slide *loadedSlide) {
+	if slide == show.slides[show.index] || !slide.loaded || slide.err != nil || slide.prepared {
+		return
+	}
+
+	// The slideshow hasn't been drawn yet.
+	if show.state.IsEmpty() {
+		return
+	}
+
+	slide.prepared = true
+	slide.img.Update(show.state)
+}
+
+// evict drops all slides other than the current one and its neighbors, so
+// their SIXELs can be garbage collected.
+func (show *Slideshow) evict() {
+	for index := range show.slides {
+		switch index {
+		case show.index, show.wrap(show.index + 1), show.wrap(show.index - 1):
+			continue
+		}
+
+		delete(show.slides, index)
+	}
+}
+
+func (show *Slideshow) placeAll() {
+	for _, slide := range show.slides {
+		show.place(slide)
+	}
+}
+
+// place positions the slide's image within the slideshow's bounds if possible.
+func (show *Slideshow) place(slide *loadedSlide) {
+	if !slide.loaded || slide.err != nil {
+		return
+	}
+
+	// Imagers given by the user are used as-is.
+	if slide.fixed {
+		return
+	}
+
+	if placer, ok := slide.img.(slidePlacer); ok {
+		placer.SetPosition(show.bounds.Min)
+		placer.SetSize(show.bounds.Size())
+	}
+}
@@ -0,0 +1,80 @@
+package tsixel
+
+import "image"
+
+// SnapPt returns the size in pixels nearest to the given one that is a whole
+// number of cells and, vertically, also a whole number of SIXEL strips. Images
+// of such sizes fill their cells exactly, so pixel art is drawn without a
+// blurry partial cell on its edges. The returned size is at least one unit
+// large. If DrawState's cell size is a zero-value, then a zero point is
+// returned.
+func (sz DrawState) SnapPt(pt image.Point) image.Point {
+	return snapPt(sz.CellSize(), pt, false)
+}
+
+// snapUnit returns the smallest size in pixels that snapping is done in
+// multiples of.
+func snapUnit(cell image.Point) image.Point {
+	return image.Pt(cell.X, lcm(cell.Y, SIXELHeight))
+}
+
+// snapPt snaps the given size to the nearest multiple of the snapping unit. If
+// down is true, then the size is rounded down instead, so it never grows; sizes
+// smaller than the unit are then kept as-is.
+func snapPt(cell image.Point, pt image.Point, down bool) image.Point {
+	if cell.X <= 0 || cell.Y <= 0 {
+		return image.Point{}
+	}
+
+	unit := snapUnit(cell)
+
+	return image.Point{
+		X: snapLength(pt.X, unit.X, down),
+		Y: snapLength(pt.Y, unit.Y, down),
+	}
+}
+
+// snapRatio snaps the given size down similarly to snapPt, except both sides
+// are shrunk by the same factor to keep the aspect ratio. Only the side that
+// shrinks the most is a whole number of units.
+func snapRatio(cell image.Point, pt image.Point) image.Point {
+	snapped := snapPt(cell, pt, true)
+	if pt.X <= 0 || pt.Y <= 0 {
+		return snapped
+	}
+
+	// Compare the factors of both sides without dividing.
+	if snapped.X*pt.Y < snapped.Y*pt.X {
+		return image.Pt(snapped.X, pt.Y*snapped.X/pt.X)
+	}
+
+	return image.Pt(pt.X*snapped.Y/pt.Y, snapped.Y)
+}
+
+func snapLength(n, unit int, down bool) int {
+	if down {
+		if n < unit {
+			return n
+		}
+		return n - n%unit
+	}
+
+	n += unit / 2
+	n -= n % unit
+	if n < unit {
+		n = unit
+	}
+
+	return n
+}
+
+func lcm(a, b int) int {
+	return a / gcd(a, b) * b
+}
+
+func gcd(a, b int) int {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
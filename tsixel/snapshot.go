@@ -0,0 +1,130 @@
+package tsixel
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+
+	"github.com/gdamore/tcell/v2"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+// ErrEmptyScreen is returned by Snapshot if the screen has no cells.
+var ErrEmptyScreen = errors.New("screen is empty")
+
+// Default colors used by Snapshot for cells with the default style.
+var (
+	SnapshotForeground color.Color = color.RGBA{0xCC, 0xCC, 0xCC, 0xFF}
+	SnapshotBackground color.Color = color.RGBA{0x00, 0x00, 0x00, 0xFF}
+)
+
+// snapshotFace is the bitmap font that cells are drawn with.
+var snapshotFace = basicfont.Face7x13
+
+// Snapshot composites the screen's cells and all drawn images into a single
+// image, which is useful for taking screenshots for bug reports and docs. The
+// cells are drawn using a bundled bitmap font, so the text will not look like
+// it does in the terminal. Images are only drawn if the screen is drawing
+// SIXEL.
+func (s *Screen) Snapshot() (image.Image, error) {
+	s.l.Lock()
+	cells := s.sstate.Cells
+	cell := s.sstate.CellSize()
+	renderer := s.renderer
+	s.l.Unlock()
+
+	if cells.X <= 0 || cells.Y <= 0 {
+		return nil, ErrEmptyScreen
+	}
+
+	// Fallback renderers already draw images as cells, so use the font's size.
+	if renderer != RendererSIXEL || cell.X <= 0 || cell.Y <= 0 {
+		cell = image.Pt(snapshotFace.Width, snapshotFace.Height)
+	}
+
+	dst := image.NewRGBA(image.Rectangle{Max: image.Pt(cells.X*cell.X, cells.Y*cell.Y)})
+
+	for y := 0; y < cells.Y; y++ {
+		for x := 0; x < cells.X; x++ {
+			r, comb, style, _ := s.s.GetContent(x, y)
+			rect := image.Rect(x*cell.X, y*cell.Y, (x+1)*cell.X, (y+1)*cell.Y)
+
+			drawSnapshotCell(dst, rect, r, comb, style)
+		}
+	}
+
+	if renderer != RendererSIXEL {
+		return dst, nil
+	}
+
+	// Hold the lock while decoding, since the SIXELs are only valid until the
+	// next draw.
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	for _, img := range s.images {
+		if img.frame.Err != nil || len(img.frame.SIXEL) == 0 {
+			continue
+		}
+
+		size := img.frame.Bounds.Size()
+
+		pixels, err := decodeSIXEL(img.frame.SIXEL, image.Pt(size.X*cell.X, size.Y*cell.Y))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode image at %v: %w", img.frame.Bounds, err)
+		}
+
+		pt := image.Pt(img.frame.Bounds.Min.X*cell.X, img.frame.Bounds.Min.Y*cell.Y)
+		draw.Draw(dst, pixels.Rect.Sub(pixels.Rect.Min).Add(pt), pixels, pixels.Rect.Min, draw.Over)
+	}
+
+	return dst, nil
+}
+
+// drawSnapshotCell draws a single cell onto the given region.
+func drawSnapshotCell(dst *image.RGBA, rect image.Rectangle, r rune, comb []rune, style tcell.Style) {
+	fgc, bgc, attrs := style.Decompose()
+
+	fg := snapshotColor(fgc, SnapshotForeground)
+	bg := snapshotColor(bgc, SnapshotBackground)
+
+	if attrs&tcell.AttrReverse != 0 {
+		fg, bg = bg, fg
+	}
+
+	draw.Draw(dst, rect, image.NewUniform(bg), image.Point{}, draw.Src)
+
+	if r == 0 || r == ' ' {
+		return
+	}
+
+	// Clip the glyph to the cell.
+	drawer := font.Drawer{
+		Dst:  dst.SubImage(rect).(*image.RGBA),
+		Src:  image.NewUniform(fg),
+		Face: snapshotFace,
+		Dot:  fixed.P(rect.Min.X, rect.Min.Y+snapshotFace.Ascent),
+	}
+
+	drawer.DrawString(string(r))
+
+	for _, r := range comb {
+		drawer.Dot = fixed.P(rect.Min.X, rect.Min.Y+snapshotFace.Ascent)
+		drawer.DrawString(string(r))
+	}
+}
+
+// snapshotColor converts the tcell color, or returns def if it's the default
+// color.
+func snapshotColor(c tcell.Color, def color.Color) color.Color {
+	r, g, b := c.RGB()
+	if r < 0 {
+		return def
+	}
+
+	return color.RGBA{uint8(r), uint8(g), uint8(b), 0xFF}
+}
@@ -0,0 +1,146 @@
+package tsixel
+
+import (
+	"bytes"
+	"image"
+)
+
+// DefaultMaxSIXELHeight is the default maximum height in pixels of a single
+// SIXEL sent to the terminal. It matches xterm's default limit.
+const DefaultMaxSIXELHeight = 1000 // px
+
+// escST is the string terminator that ends a SIXEL sequence.
+const escST = "\x1b\\"
+
+// SetMaxSIXELHeight sets the maximum height in pixels of a single SIXEL that
+// the terminal draws. Terminals cut off SIXELs taller than their limit, so
+// taller images are split into multiple SIXELs stacked vertically when they're
+// drawn. The height is rounded down to line up with both the cells and the
+// SIXEL bands. A height of 0 or less disables splitting. The default is
+// DefaultMaxSIXELHeight.
+func (s *Screen) SetMaxSIXELHeight(px int) {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	s.maxHeight = px
+}
+
+// writeSIXEL writes the escape sequences to draw the given SIXEL at the given
+// cell. If the SIXEL is taller than maxHeight, then it is split into multiple
+// SIXELs, each positioned at its own row.
+func writeSIXEL(buf *bytes.Buffer, b []byte, at, cell image.Point, maxHeight int) {
+	head, body, ok := splitSIXELBody(b)
+	if !ok || maxHeight <= 0 || cell.Y <= 0 {
+		writeCursorPosition(buf, at)
+		buf.Write(b)
+		return
+	}
+
+	// Each part must start on both a new cell and a new band.
+	unit := snapUnit(cell).Y
+	height := maxHeight - maxHeight%unit
+	if height == 0 {
+		height = unit
+	}
+
+	bands := height / SIXELHeight
+	if bytes.Count(body, []byte{'-'}) < bands {
+		writeCursorPosition(buf, at)
+		buf.Write(b)
+		return
+	}
+
+	debugf("splitting SIXEL at %v into parts of %d px", at, height)
+
+	for row := at.Y; len(body) > 0; row += height / cell.Y {
+		end := indexNth(body, '-', bands)
+
+		writeCursorPosition(buf, image.Pt(at.X, row))
+		buf.Write(head)
+		buf.Write(body[:end])
+		buf.WriteString(escST)
+
+		if end < len(body) {
+			end++ // skip the band separator
+		}
+		body = body[end:]
+	}
+}
+
+// splitSIXELBody splits the SIXEL into its head and its body. The head contains
+// the header and the color definitions, so it can be repeated for each part;
+// raster attributes declaring the size are dropped from it, since each part is
+// smaller. The body contains the bands separated by '-' without the string
+// terminator.
+func splitSIXELBody(b []byte) (head, body []byte, ok bool) {
+	if !bytes.HasPrefix(b, []byte("\x1bP")) {
+		return nil, nil, false
+	}
+
+	end := bytes.LastIndex(b, []byte(escST))
+	q := bytes.IndexByte(b, 'q')
+	if end == -1 || q == -1 || q > end {
+		return nil, nil, false
+	}
+
+	head = append(head, b[:q+1]...)
+	i := q + 1
+
+loop:
+	for i < end {
+		switch b[i] {
+		case '"':
+			j := skipParams(b, i+1)
+			// Keep only the pixel aspect ratio.
+			params := bytes.SplitN(b[i+1:j], []byte{';'}, 3)
+			head = append(head, '"')
+			head = append(head, bytes.Join(params[:minInt(len(params), 2)], []byte{';'})...)
+			i = j
+			continue
+
+		case '#':
+			j := skipParams(b, i+1)
+			// Color definitions have 5 parameters; a color selection only has
+			// one, which starts the body.
+			if bytes.Count(b[i+1:j], []byte{';'}) == 4 {
+				head = append(head, b[i:j]...)
+				i = j
+				continue
+			}
+		}
+
+		break loop
+	}
+
+	return head, b[i:end], true
+}
+
+// skipParams returns the index of the first byte from i that is not part of
+// the numeric parameters.
+func skipParams(b []byte, i int) int {
+	for i < len(b) && (b[i] == ';' || (b[i] >= '0' && b[i] <= '9')) {
+		i++
+	}
+	return i
+}
+
+// indexNth returns the index of the nth occurrence of c in b, or len(b) if
+// there are fewer.
+func indexNth(b []byte, c byte, n int) int {
+	i := 0
+	for ; n > 0; n-- {
+		j := bytes.IndexByte(b[i:], c)
+		if j == -1 {
+			return len(b)
+		}
+		i += j + 1
+	}
+	return i - 1
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -2,8 +2,11 @@
 package tsixel
 
 import (
+	"bytes"
 	"errors"
+	"hash/fnv"
 	"image"
+	"strconv"
 	"sync"
 	"time"
 
@@ -42,8 +45,30 @@ type Screen struct {
 	s tcell.Screen
 	l sync.Locker
 
-	images map[Imager]*drawnImage
+	images []*drawnImage // in draw order
 	sstate DrawState
+
+	debug    bool
+	onBounds BoundsChangedFunc
+	notifier boundsNotifier // delivers onBounds calls in order
+	refresh  bool           // force redrawing all images on the next draw
+	renderer Renderer
+
+	noGraphics bool // true if images are not drawn
+	maxHeight  int  // of each SIXEL in pixels, 0 to not split
+
+	// application intercepts in order
+	intercepts      []tcell.DrawInterceptFunc
+	interceptsAfter []tcell.DrawInterceptFunc
+
+	drawBuf bytes.Buffer // reused by afterDraw
+
+	// pausing states
+	paused    bool
+	suspended bool
+	pausedAt  time.Time
+	pausedFor time.Duration // total paused duration
+	holding   bool          // true if holding the main resize pipeline
 }
 
 // Imager represents an image interface.
@@ -65,12 +90,91 @@ type Frame struct {
 	// MustUpdate, if true, will force the screen to redraw the SIXEL. The
 	// screen may still redraw the SIXEL if this is false.
 	MustUpdate bool
+	// Err, if not nil, is the error that occured while encoding the image. The
+	// screen will draw an error placeholder within Bounds instead of the
+	// SIXEL.
+	Err error
+	// EncodeTime is the time taken to encode the current SIXEL. It is only
+	// used for statistics and may be zero if unknown.
+	EncodeTime time.Duration
+	// Degradation describes how the SIXEL was degraded to fit the image's
+	// MaxBytes. It is zero if the SIXEL was not degraded.
+	Degradation Degradation
+	// Opaque is the region in units of cells that the SIXEL fully covers with
+	// opaque pixels. Since the cells within it are never visible, the screen
+	// doesn't redraw them over the SIXEL when they change. It may be empty if
+	// unknown.
+	Opaque image.Rectangle
+	// Redraw is the policy of the image when the cells under it change.
+	Redraw RedrawPolicy
+	// Letterbox, if not empty, is the region in units of cells that the
+	// screen fills with LetterboxStyle, except for the cells within Bounds.
+	Letterbox      image.Rectangle
+	LetterboxStyle tcell.Style
+
+	// pixels, if not nil, is the scaled image that the SIXEL was encoded
+	// from. Fallback renderers draw it instead of decoding the SIXEL.
+	pixels *image.NRGBA
+}
+
+// Geometry describes the requested and actual geometry of an image.
+type Geometry struct {
+	// Requested is the region requested by the caller in units of cells. It
+	// may be larger than Cells.
+	Requested image.Rectangle
+	// Cells is the region that the image is drawn onto in units of cells.
+	Cells image.Rectangle
+	// Pixels is the size of the drawn SIXEL in pixels.
+	Pixels image.Point
+}
+
+// GeometryImager is an Imager that can report its geometry. All Imagers in
+// this package implement it.
+type GeometryImager interface {
+	Imager
+	// Geometry returns the current geometry of the image. The returned
+	// geometry is zero if the image has never been updated.
+	Geometry() Geometry
 }
 
+var (
+	_ GeometryImager = (*Image)(nil)
+	_ GeometryImager = (*Animation)(nil)
+	_ GeometryImager = (*StaticImage)(nil)
+)
+
+// Cloner is an Imager that can be displayed on multiple screens. Since an
+// Imager's state depends on the screen it is on, each screen needs its own
+// instance; a Cloner creates one that shares the decoded source.
+type Cloner interface {
+	Imager
+	// CloneFor creates a new Imager that shares the same source and adds it
+	// onto the given screen. The clone is returned.
+	CloneFor(screen *Screen) Imager
+}
+
+var (
+	_ Cloner = (*Image)(nil)
+	_ Cloner = (*Animation)(nil)
+	_ Cloner = (*StaticImage)(nil)
+)
+
 // drawnImage is a stateful image wrapper for damage tracking.
 type drawnImage struct {
 	Imager
 	frame Frame
+
+	redraws int    // number of times the SIXEL was drawn
+	sent    uint64 // hash of the last transmitted SIXEL
+
+	// cells marked clean under the image, which must be marked dirty again
+	// once they're uncovered
+	cleaned image.Rectangle
+
+	// drawn pixels for fallback renderers, decoded from pixelsOf if the
+	// image doesn't keep its pixels
+	pixels   *image.NRGBA
+	pixelsOf []byte
 }
 
 // WrapInitScreen wraps around an initialized tcell screen to create a new
@@ -78,8 +182,14 @@ type drawnImage struct {
 // capable of outputting SIXEL. Note that this does not check if the terminal
 // can draw SIXEL images. This behavior may change in the future.
 func WrapInitScreen(s tcell.Screen) (*Screen, error) {
-	if _, ok := s.(tcell.DirectDrawer); !ok {
-		return nil, ErrNoDirectDrawer
+	return wrapInitScreen(s, RendererSIXEL)
+}
+
+func wrapInitScreen(s tcell.Screen, renderer Renderer) (*Screen, error) {
+	if renderer == RendererSIXEL {
+		if _, ok := s.(tcell.DirectDrawer); !ok {
+			return nil, ErrNoDirectDrawer
+		}
 	}
 
 	iceptAdder, ok := s.(tcell.DrawInterceptAdder)
@@ -92,55 +202,145 @@ func WrapInitScreen(s tcell.Screen) (*Screen, error) {
 		return nil, ErrNoExplicitSync
 	}
 
-	pxsz, ok := s.(tcell.PixelSizer)
-	if !ok {
-		return nil, ErrNoPixelDimensions
+	if renderer == RendererSIXEL {
+		if err := checkSIXELCapable(s); err != nil {
+			return nil, err
+		}
 	}
 
 	sstate := DrawState{
 		Delegate: s.Show,
-		Cells:    image.Pt(s.Size()),
-		Pixels:   image.Pt(pxsz.PixelSize()),
-	}
-
-	// Confirm that the screen actually supports pixel sizes.
-	if sstate.Pixels == image.Pt(0, 0) {
-		return nil, ErrNoPixelDimensions
 	}
+	sstate.update(s, false, time.Now(), renderer)
 
 	screen := Screen{
-		s:      s,
-		l:      locker,
-		sstate: sstate,
-		images: map[Imager]*drawnImage{},
+		s:         s,
+		l:         locker,
+		sstate:    sstate,
+		renderer:  renderer,
+		maxHeight: DefaultMaxSIXELHeight,
 	}
 
-	iceptAdder.AddDrawIntercept(screen.beforeDraw)
-	iceptAdder.AddDrawInterceptAfter(screen.afterDraw)
+	iceptAdder.AddDrawIntercept(screen.intercept)
+	iceptAdder.AddDrawInterceptAfter(screen.interceptAfter)
 	return &screen, nil
 }
 
+// checkSIXELCapable returns an error if the screen is not capable of drawing
+// SIXEL.
+func checkSIXELCapable(s tcell.Screen) error {
+	if _, ok := s.(tcell.DirectDrawer); !ok {
+		return ErrNoDirectDrawer
+	}
+
+	pxsz, ok := s.(tcell.PixelSizer)
+	if !ok {
+		return ErrNoPixelDimensions
+	}
+
+	// Confirm that the screen actually supports pixel sizes.
+	if w, h := pxsz.PixelSize(); w == 0 && h == 0 {
+		return ErrNoPixelDimensions
+	}
+
+	return nil
+}
+
 // beforeDraw is responsible for damage tracking.
 func (s *Screen) beforeDraw(screen tcell.Screen, sync bool) bool {
-	s.sstate.update(screen, sync)
+	s.sstate.update(screen, sync, s.now(), s.renderer)
+	debugf("drawing %d images (sync: %v, refresh: %v)", len(s.images), sync, s.refresh)
+
+	if s.noGraphics {
+		return sync
+	}
+
+	// Don't bother updating images if there's nowhere to draw them. They are
+	// updated again once the screen has a size, and since their bounds will
+	// have changed, the screen is then cleared.
+	if s.sstate.IsEmpty() {
+		debugf("screen is empty (%v cells, %v pixels), not drawing images", s.sstate.Cells, s.sstate.Pixels)
+		for _, img := range s.images {
+			img.frame = Frame{}
+		}
+		return sync
+	}
 
 	viewer, hasCellBuffer := screen.(tcell.CellBufferViewer)
 
 	// Clear dead images by redrawing completely.
 	var clear = sync
 
+	// Redraw all images if we're asked to, but don't clear the screen.
+	refresh := sync || s.refresh
+	s.refresh = false
+
+	var changes []boundsChange
+	defer func() { s.notifyBounds(changes) }()
+
 	for _, img := range s.images {
 		oldFrame := img.frame
 		img.frame = img.Update(s.sstate)
 
-		if sync {
+		if s.onBounds != nil && !img.frame.Bounds.Eq(oldFrame.Bounds) {
+			changes = append(changes, boundsChange{
+				img: img.Imager,
+				old: oldFrame.Bounds,
+				new: img.frame.Bounds,
+			})
+		}
+
+		if !img.frame.Letterbox.Empty() {
+			drawLetterbox(screen, img.frame)
+		}
+
+		if img.frame.Redraw == ClearCellsFirst {
+			clearRegion(screen, img.frame.Bounds)
+		}
+
+		if img.frame.Err != nil {
+			drawErrorPlaceholder(screen, img.frame.Bounds, img.frame.Err)
+		}
+
+		if refresh {
 			img.frame.MustUpdate = true
+		}
+
+		if s.renderer != RendererSIXEL {
+			s.drawFallback(screen, img)
+			continue
+		}
+
+		if sync {
+			// Syncing draws all cells again, so none are clean anymore.
+			img.cleaned = image.Rectangle{}
 			continue
 		}
 
 		if !clear {
 			// We must clear the screen if the bounds changed.
 			clear = !img.frame.Bounds.Eq(oldFrame.Bounds)
+			if clear {
+				debugf("image moved from %v to %v, clearing screen", oldFrame.Bounds, img.frame.Bounds)
+			}
+		}
+
+		// Don't transmit the same SIXEL again if it's still on the terminal.
+		// It is only redrawn if the cells over it are damaged.
+		if img.frame.MustUpdate && !refresh && hasCellBuffer &&
+			img.frame.Bounds.Eq(oldFrame.Bounds) &&
+			img.sent != 0 && img.sent == hashSIXEL(img.frame.SIXEL) {
+
+			debugf("image at %v is unchanged, skipping redraw", img.frame.Bounds)
+			img.frame.MustUpdate = false
+		}
+
+		// The image is redrawn over its cells, so tcell may draw the cells
+		// that were cleaned under it again.
+		if img.frame.MustUpdate && hasCellBuffer && !img.cleaned.Empty() {
+			viewer.ViewCellBuffer(func(cb *tcell.CellBuffer) {
+				img.clean(cb, image.Rectangle{})
+			})
 		}
 
 		// We only check if we need to redraw if we haven't resized. We ALWAYS
@@ -149,46 +349,191 @@ func (s *Screen) beforeDraw(screen tcell.Screen, sync bool) bool {
 			r := img.frame.Bounds
 
 			viewer.ViewCellBuffer(func(cb *tcell.CellBuffer) {
+				// Don't let tcell draw the cells hidden under the image over
+				// it.
+				var hidden image.Rectangle
+				if !clear && img.frame.Err == nil {
+					hidden = img.frame.Opaque.Intersect(r)
+					if img.frame.Redraw == IgnoreCellDamage {
+						hidden = r
+					}
+				}
+
+				img.clean(cb, hidden)
+
 				img.frame.MustUpdate = cb.DirtyRegion(r.Min.X, r.Min.Y, r.Max.X, r.Max.Y)
+				if img.frame.MustUpdate {
+					debugf("cells under image at %v are damaged, redrawing", r)
+				}
 
 				// Invalidate cells if we're going to clear the screen, so tcell
 				// can redraw the terminal.
 				if clear {
+					debugf("invalidating cells for clearing the screen")
 					cb.Invalidate()
 				}
 			})
 		}
 	}
 
+	// Fallback renderers draw in cells, so the overlay must be drawn now.
+	if s.debug && s.renderer != RendererSIXEL {
+		s.drawDebugCells(screen)
+	}
+
 	return clear
 }
 
 // afterDraw is responsible for putting SIXEL images on the screen.
 func (s *Screen) afterDraw(screen tcell.Screen, sync bool) bool {
+	if s.noGraphics || s.renderer != RendererSIXEL {
+		return false
+	}
+
 	drawer, _ := screen.(tcell.DirectDrawer)
 
+	// Position the SIXELs using escape sequences within a single write, and
+	// restore the cursor afterwards, so that tcell's cursor is left alone.
+	s.drawBuf.Reset()
+	s.drawBuf.WriteString(escSaveCursor)
+
+	cell := s.sstate.CellSize()
+
 	for _, img := range s.images {
+		if img.frame.Err != nil || len(img.frame.SIXEL) == 0 {
+			continue
+		}
+
 		if img.frame.MustUpdate || sync {
-			screen.ShowCursor(img.frame.Bounds.Min.X, img.frame.Bounds.Min.Y)
-			drawer.DrawDirectly(img.frame.SIXEL)
+			debugf("sending %d bytes of SIXEL at %v", len(img.frame.SIXEL), img.frame.Bounds)
+			writeSIXEL(&s.drawBuf, img.frame.SIXEL, img.frame.Bounds.Min, cell, s.maxHeight)
+			img.redraws++
+			img.sent = hashSIXEL(img.frame.SIXEL)
 		}
 	}
 
-	screen.HideCursor()
-	drawer.DrawDirectly(nil)
+	if s.debug {
+		s.drawDebug(&s.drawBuf)
+	}
+
+	if s.drawBuf.Len() > len(escSaveCursor) {
+		s.drawBuf.WriteString(escRestoreCursor)
+		debugf("drawing %d bytes directly", s.drawBuf.Len())
+		drawer.DrawDirectly(s.drawBuf.Bytes())
+	}
 
 	return false
 }
 
+// Escape sequences to save and restore the cursor position (DECSC and DECRC).
+const (
+	escSaveCursor    = "\x1b7"
+	escRestoreCursor = "\x1b8"
+)
+
+// writeCursorPosition writes the escape sequence to move the cursor to the
+// given cell (CUP).
+func writeCursorPosition(buf *bytes.Buffer, pt image.Point) {
+	buf.WriteString("\x1b[")
+	buf.WriteString(strconv.Itoa(pt.Y + 1))
+	buf.WriteByte(';')
+	buf.WriteString(strconv.Itoa(pt.X + 1))
+	buf.WriteByte('H')
+}
+
+// hashSIXEL returns the hash of the SIXEL data. A zero hash is returned for
+// empty data.
+func hashSIXEL(b []byte) uint64 {
+	if len(b) == 0 {
+		return 0
+	}
+
+	h := fnv.New64a()
+	h.Write(b)
+	return h.Sum64()
+}
+
+// ErrorStyle is the style used to draw the placeholder of images that failed to
+// encode.
+var ErrorStyle = tcell.StyleDefault.Reverse(true)
+
+// drawErrorPlaceholder fills the given region with ErrorStyle and writes the
+// error on its first line, truncated to fit.
+func drawErrorPlaceholder(screen tcell.Screen, rect image.Rectangle, err error) {
+	msg := []rune("! " + err.Error())
+
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			r := ' '
+			if i := x - rect.Min.X; y == rect.Min.Y && i < len(msg) {
+				r = msg[i]
+			}
+
+			screen.SetContent(x, y, r, nil, ErrorStyle)
+		}
+	}
+}
+
+// drawLetterbox fills the frame's letterbox around its bounds.
+func drawLetterbox(screen tcell.Screen, frame Frame) {
+	for y := frame.Letterbox.Min.Y; y < frame.Letterbox.Max.Y; y++ {
+		for x := frame.Letterbox.Min.X; x < frame.Letterbox.Max.X; x++ {
+			if !image.Pt(x, y).In(frame.Bounds) {
+				screen.SetContent(x, y, ' ', nil, frame.LetterboxStyle)
+			}
+		}
+	}
+}
+
+// cleanRegion marks the cells within the region as not dirty.
+func cleanRegion(cb *tcell.CellBuffer, rect image.Rectangle) {
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			cb.SetDirty(x, y, false)
+		}
+	}
+}
+
+// clean marks the cells within the given region clean, so that tcell doesn't
+// draw them over the SIXEL. The cells that were cleaned before but are outside
+// of the region are marked dirty again, so that tcell draws them once they're
+// no longer covered by the image.
+func (img *drawnImage) clean(cb *tcell.CellBuffer, hidden image.Rectangle) {
+	old := img.cleaned
+
+	for y := old.Min.Y; y < old.Max.Y; y++ {
+		for x := old.Min.X; x < old.Max.X; x++ {
+			if !image.Pt(x, y).In(hidden) {
+				cb.SetDirty(x, y, true)
+			}
+		}
+	}
+
+	cleanRegion(cb, hidden)
+	img.cleaned = hidden
+}
+
+// clearRegion clears the cells within the region.
 func clearRegion(screen tcell.Screen, rect image.Rectangle) {
 	// Loop over Y first for cache locality.
-	for y := rect.Min.Y; y < rect.Min.Y; y++ {
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
 		for x := rect.Min.X; x < rect.Max.X; x++ {
 			screen.SetContent(x, y, ' ', nil, tcell.StyleDefault)
 		}
 	}
 }
 
+// Refresh forces all images to be sent to the terminal again and redraws the
+// screen. Unlike Sync, the screen is not cleared. It is useful if the terminal
+// drops its graphics, such as after switching screens or reattaching.
+func (s *Screen) Refresh() {
+	s.l.Lock()
+	s.refresh = true
+	s.l.Unlock()
+
+	s.s.Show()
+}
+
 // AddImage adds a SIXEL image onto the screen. This method will not redraw, so
 // the caller should call Sync on the screen.
 func (s *Screen) AddImage(img Imager) {
@@ -196,7 +541,14 @@ func (s *Screen) AddImage(img Imager) {
 	defer s.l.Unlock()
 
 	img.Update(s.sstate)
-	s.images[img] = &drawnImage{Imager: img}
+
+	// Keep the image's place in the draw order if it's already added.
+	if i := s.imageIndex(img); i != -1 {
+		s.images[i] = &drawnImage{Imager: img, cleaned: s.images[i].cleaned}
+		return
+	}
+
+	s.images = append(s.images, &drawnImage{Imager: img})
 }
 
 // AddAnyImage adds any image type onto the screen. It is a convenient wrapper
@@ -212,7 +564,66 @@ func (s *Screen) RemoveImage(img Imager) {
 	s.l.Lock()
 	defer s.l.Unlock()
 
-	delete(s.images, img)
+	i := s.imageIndex(img)
+	if i == -1 {
+		return
+	}
+
+	// Let tcell draw the cells that were hidden under the image again.
+	if viewer, ok := s.s.(tcell.CellBufferViewer); ok && !s.images[i].cleaned.Empty() {
+		viewer.ViewCellBuffer(func(cb *tcell.CellBuffer) {
+			s.images[i].clean(cb, image.Rectangle{})
+		})
+	}
+
+	// Preserve the draw order of the other images.
+	copy(s.images[i:], s.images[i+1:])
+	s.images[len(s.images)-1] = nil
+	s.images = s.images[:len(s.images)-1]
+}
+
+// Images returns all images on the screen in draw order. Images are drawn in
+// the order they were added, so later images are drawn over earlier ones.
+func (s *Screen) Images() []Imager {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	images := make([]Imager, len(s.images))
+	for i, img := range s.images {
+		images[i] = img.Imager
+	}
+
+	return images
+}
+
+// FrameOf returns the last frame drawn for the given image. False is returned
+// if the image is not on the screen. Unless Err is set, MustUpdate tells whether
+// the SIXEL was sent to the terminal on the last draw. The returned SIXEL bytes
+// are a copy, since the image may reuse its buffer once it's drawn again.
+func (s *Screen) FrameOf(img Imager) (Frame, bool) {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	i := s.imageIndex(img)
+	if i == -1 {
+		return Frame{}, false
+	}
+
+	frame := s.images[i].frame
+	frame.SIXEL = append([]byte(nil), frame.SIXEL...)
+
+	return frame, true
+}
+
+// imageIndex returns the index of the given image in the draw order, or -1 if
+// the image is not on the screen.
+func (s *Screen) imageIndex(img Imager) int {
+	for i, drawn := range s.images {
+		if drawn.Imager == img {
+			return i
+		}
+	}
+	return -1
 }
 
 // DrawState stores the screen size in two units: cells and pixels.
@@ -226,26 +637,105 @@ type DrawState struct {
 	Sync   bool
 	Cells  image.Point
 	Pixels image.Point
+
+	// Offline, if true, makes images encode synchronously inside Update
+	// instead of going through the resize pipeline, so the returned Frame
+	// always contains the SIXEL for the current geometry. It is useful for
+	// driving images without a live Screen.
+	Offline bool
+
+	cell     image.Point // tracked cell size, zero if untracked
+	renderer Renderer    // renderer that images are drawn with
 }
 
-func (sz *DrawState) update(screen tcell.Screen, sync bool) {
-	sz.Time = time.Now()
+// NewDrawState creates a new offline DrawState with the given screen size in
+// cells and pixels. It can be given to Imager.Update directly to pre-compute
+// SIXEL output without a tcell screen. Both sizes must not be zero.
+func NewDrawState(cells, pixels image.Point) DrawState {
+	return DrawState{
+		Delegate: func() {},
+		Time:     time.Now(),
+		Sync:     true,
+		Cells:    cells,
+		Pixels:   pixels,
+		Offline:  true,
+	}
+}
+
+func (sz *DrawState) update(screen tcell.Screen, sync bool, now time.Time, r Renderer) {
+	sz.Time = now
 	sz.Sync = sync
 
+	sz.renderer = r
+
 	sz.Cells.X, sz.Cells.Y = screen.Size()
 
-	pxsz, _ := screen.(tcell.PixelSizer)
-	sz.Pixels.X, sz.Pixels.Y = pxsz.PixelSize()
+	// Fallback renderers draw with their own cell size.
+	if cell := r.cellPixels(); cell != (image.Point{}) {
+		sz.Pixels = image.Pt(sz.Cells.X*cell.X, sz.Cells.Y*cell.Y)
+	} else if pxsz, ok := screen.(tcell.PixelSizer); ok {
+		sz.Pixels.X, sz.Pixels.Y = pxsz.PixelSize()
+	}
+
+	sz.cell = trackCellSize(sz.cell, sz.Pixels, sz.Cells)
+}
+
+// IsEmpty returns true if the screen has no cells or no pixels, such as when
+// the terminal is detached or its pane is collapsed. Images are not drawn onto
+// empty screens.
+func (sz DrawState) IsEmpty() bool {
+	return sz.Cells.X <= 0 || sz.Cells.Y <= 0 || sz.Pixels.X <= 0 || sz.Pixels.Y <= 0
 }
 
-// CellSize returns the size of each cell in pixels.
+// CellSize returns the size of each cell in pixels. For states drawn by a
+// Screen, the cell size only changes when the pixel size per cell genuinely
+// changes, so images are not needlessly encoded again when the screen's pixel
+// size does not divide evenly.
 func (sz DrawState) CellSize() image.Point {
+	if sz.cell != (image.Point{}) {
+		return sz.cell
+	}
+
+	if sz.Cells.X == 0 || sz.Cells.Y == 0 {
+		return image.Point{}
+	}
+
 	return image.Point{
 		X: sz.Pixels.X / sz.Cells.X,
 		Y: sz.Pixels.Y / sz.Cells.Y,
 	}
 }
 
+// cellHysteresis is the number of pixels that the exact cell size must grow
+// past the next whole pixel before the tracked cell size grows.
+const cellHysteresis = 0.25
+
+// trackCellSize returns the new cell size from the previous one. The cell size
+// is rounded down so that cells never add up to more than the pixel size. It
+// shrinks immediately, but it only grows once the exact size is past the
+// hysteresis, so that an exact size fluctuating around a whole pixel doesn't
+// change the cell size back and forth.
+func trackCellSize(prev, pixels, cells image.Point) image.Point {
+	if cells.X <= 0 || cells.Y <= 0 || pixels.X <= 0 || pixels.Y <= 0 {
+		return image.Point{}
+	}
+
+	return image.Point{
+		X: trackCellLength(prev.X, pixels.X, cells.X),
+		Y: trackCellLength(prev.Y, pixels.Y, cells.Y),
+	}
+}
+
+func trackCellLength(prev, pixels, cells int) int {
+	exact := float64(pixels) / float64(cells)
+
+	if prev > 0 && exact >= float64(prev) && exact < float64(prev+1)+cellHysteresis {
+		return prev
+	}
+
+	return pixels / cells
+}
+
 // SIXELHeight is the height of a single SIXEL strip.
 //
 // According to Wikipedia, the free encyclopedia:
@@ -287,10 +777,10 @@ func ptInCells(cell image.Point, pt image.Point) image.Point {
 }
 
 // RoundPt rounds a pixel point to be within SIXEL multiples. If DrawState's
-// cell size is a zero-value, then a zero point is returned.
+// cell size or the given point is a zero-value, then a zero point is returned.
 func (sz DrawState) RoundPt(pt image.Point) image.Point {
 	cell := sz.CellSize()
-	if cell.X == 0 || cell.Y == 0 {
+	if cell.X == 0 || cell.Y == 0 || pt.X <= 0 || pt.Y <= 0 {
 		return image.Point{}
 	}
 
@@ -302,7 +792,7 @@ func (sz DrawState) RoundPt(pt image.Point) image.Point {
 
 	// Round the image down to the cell size after we changed the size to no
 	// longer round.
-	if excessY > 0 {
+	if excessY > 0 && pt.X > 0 {
 		excessX := pt.X % cell.X
 
 		pt.Y -= ceilDiv(pt.Y*excessX, pt.X)